@@ -1,14 +1,27 @@
 package copy
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
 	"time"
 
 	. "github.com/otiai10/mint"
@@ -163,6 +176,14 @@ func TestCopy_NamedPipe(t *testing.T) {
 		Expect(t, info.Mode()&os.ModeNamedPipe != 0).ToBe(true)
 		Expect(t, info.Mode().Perm()).ToBe(os.FileMode(0o555))
 	})
+
+	When(t, "DestFS can not make named pipes", func(t *testing.T) {
+		destfs := &prefixFS{root: t.TempDir()}
+		err := Copy("test/data/case11", "case11", Options{DestFS: destfs})
+		Expect(t, errors.Is(err, errMkfifoUnsupported)).ToBe(true)
+		_, err = os.Lstat("case11")
+		Expect(t, os.IsNotExist(err)).ToBe(true)
+	})
 }
 
 func TestOptions_Skip(t *testing.T) {
@@ -476,3 +497,1276 @@ func TestOptions_RenameDestination(t *testing.T) {
 	_, err = os.Stat("test/data.copy/case20/foo/control.txt")
 	Expect(t, err).ToBe(nil)
 }
+
+func TestCopyContext(t *testing.T) {
+	When(t, "context is already canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := CopyContext(ctx, "test/data/case19", "test/data.copy/case19_canceled")
+		Expect(t, errors.Is(err, context.Canceled)).ToBe(true)
+		Expect(t, err).TypeOf("*fs.PathError")
+		_, err = os.Stat("test/data.copy/case19_canceled")
+		Expect(t, os.IsNotExist(err)).ToBe(true)
+	})
+
+	When(t, "context is canceled while copying a file", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "large.file")
+		Expect(t, os.WriteFile(src, make([]byte, 64*1024), 0o644)).ToBe(nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		opt := Options{
+			CopyBufferSize: 1024,
+			WrapReader: func(r io.Reader) io.Reader {
+				cancel()
+				return r
+			},
+		}
+		err := CopyContext(ctx, src, filepath.Join(t.TempDir(), "large.file"), opt)
+		Expect(t, errors.Is(err, context.Canceled)).ToBe(true)
+		Expect(t, err.(*fs.PathError).Path).ToBe(src)
+	})
+
+	When(t, "context is canceled with NumOfWorkers", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := CopyContext(ctx, "test/data/case19", "test/data.copy/case19_canceled_concurrent", Options{NumOfWorkers: 4})
+		Expect(t, errors.Is(err, context.Canceled)).ToBe(true)
+	})
+
+	When(t, "context is already canceled for a file", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "README.md")
+		Expect(t, os.WriteFile(dest, []byte("precious"), 0o644)).ToBe(nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := CopyContext(ctx, "test/data/case01/README.md", dest)
+		Expect(t, errors.Is(err, context.Canceled)).ToBe(true)
+		Expect(t, mustRead(t, dest)).ToBe("precious")
+	})
+
+	When(t, "context is canceled with OnError swallowing errors", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		opt := Options{OnError: func(src, dest string, err error) error { return nil }}
+		err := CopyContext(ctx, "test/data/case19", "test/data.copy/case19_canceled_onerror", opt)
+		Expect(t, errors.Is(err, context.Canceled)).ToBe(true)
+		Expect(t, err).TypeOf("*fs.PathError")
+	})
+}
+
+func TestOptions_OnProgress(t *testing.T) {
+	var last Progress
+	count := 0
+	opt := Options{
+		NumOfWorkers: 4,
+		PreScan:      true,
+		OnProgress: func(p Progress) {
+			count++
+			last = p
+		},
+	}
+	err := Copy("test/data/case19", "test/data.copy/case19_progress", opt)
+	Expect(t, err).ToBe(nil)
+	Expect(t, count > 0).ToBe(true)
+	Expect(t, last.TotalFiles).ToBe(int64(8))
+	Expect(t, last.CopiedFiles).ToBe(last.TotalFiles)
+	Expect(t, last.CopiedBytes).ToBe(last.TotalBytes)
+
+	When(t, "Skip is given", func(t *testing.T) {
+		var last Progress
+		opt := Options{
+			PreScan: true,
+			Skip: func(info os.FileInfo, src, dest string) (bool, error) {
+				return strings.HasSuffix(src, "_skip"), nil
+			},
+			OnProgress: func(p Progress) { last = p },
+		}
+		err := Copy("test/data/case06", "test/data.copy/case06_progress", opt)
+		Expect(t, err).ToBe(nil)
+		Expect(t, last.CopiedFiles).ToBe(last.TotalFiles)
+		Expect(t, last.CopiedBytes).ToBe(last.TotalBytes)
+	})
+
+	When(t, "errors are suppressed by OnError", func(t *testing.T) {
+		handled := 0
+		opt := Options{
+			PreScan:    true,
+			OnProgress: func(p Progress) {},
+			RenameDestination: func(src, dest string) (string, error) {
+				if strings.HasSuffix(src, "_skip") {
+					return "", errors.New("not renamed")
+				}
+				return dest, nil
+			},
+			OnError: func(src, dest string, err error) error {
+				if err != nil {
+					handled++
+				}
+				return nil
+			},
+		}
+		report, err := CopyWithReport("test/data/case06", "test/data.copy/case06_progress_errors", opt)
+		Expect(t, err).ToBe(nil)
+		Expect(t, handled).ToBe(2)
+		Expect(t, len(report.Errors)).ToBe(2)
+	})
+
+	When(t, "PreScan is false", func(t *testing.T) {
+		var last Progress
+		opt := Options{OnProgress: func(p Progress) { last = p }}
+		err := Copy("test/data/case01/README.md", "test/data.copy/case01_progress/README.md", opt)
+		Expect(t, err).ToBe(nil)
+		Expect(t, last.Path).ToBe("test/data/case01/README.md")
+		Expect(t, last.CopiedFiles).ToBe(int64(1))
+		Expect(t, last.CopiedBytes).ToBe(int64(len("case01 - README.md")))
+		Expect(t, last.TotalFiles).ToBe(int64(0))
+	})
+}
+
+func TestPlan(t *testing.T) {
+	opt := Options{OnDirExists: func(src, dest string) DirExistsAction { return Untouchable }}
+	ops, err := Plan("test/data/case10/src", "test/data/case10/dest", opt)
+	Expect(t, err).ToBe(nil)
+	Expect(t, len(ops)).ToBe(5)
+	Expect(t, ops[0]).ToBe(Operation{Type: OpMkdir, Src: "test/data/case10/src", Dest: "test/data/case10/dest"})
+	Expect(t, ops[1].Type).ToBe(OpMkdir)
+	Expect(t, ops[2].Type).ToBe(OpCopyFile)
+	Expect(t, ops[2].Dest).ToBe("test/data/case10/dest/bar/text_ccc")
+	Expect(t, ops[4]).ToBe(Operation{Type: OpSkip, Src: "test/data/case10/src/foo", Dest: "test/data/case10/dest/foo", Reason: "Untouchable"})
+	_, err = os.Stat("test/data/case10/dest/bar")
+	Expect(t, os.IsNotExist(err)).ToBe(true)
+
+	When(t, "OnDirExists returns Replace", func(t *testing.T) {
+		opt := Options{OnDirExists: func(src, dest string) DirExistsAction { return Replace }}
+		ops, err := Plan("test/data/case10/src", "test/data/case10/dest", opt)
+		Expect(t, err).ToBe(nil)
+		Expect(t, len(ops)).ToBe(7)
+		Expect(t, ops[4].Type).ToBe(OpReplaceDir)
+		Expect(t, ops[4].Dest).ToBe("test/data/case10/dest/foo")
+		_, err = os.Stat("test/data/case10/dest/foo/text_eee")
+		Expect(t, err).ToBe(nil)
+	})
+
+	When(t, "Skip is given", func(t *testing.T) {
+		opt := Options{Skip: func(info os.FileInfo, src, dest string) (bool, error) {
+			return strings.HasSuffix(src, "_skip"), nil
+		}}
+		ops, err := Plan("test/data/case06", "test/data.copy/case06_plan", opt)
+		Expect(t, err).ToBe(nil)
+		skipped := []string{}
+		for _, op := range ops {
+			if op.Type == OpSkip {
+				skipped = append(skipped, op.Src)
+			}
+		}
+		Expect(t, skipped).ToBe([]string{"test/data/case06/dir_skip", "test/data/case06/file_skip"})
+		_, err = os.Stat("test/data.copy/case06_plan")
+		Expect(t, os.IsNotExist(err)).ToBe(true)
+	})
+}
+
+func TestCopyWithReport(t *testing.T) {
+	report, err := CopyWithReport("test/data/case19", "test/data.copy/case19_report", Options{NumOfWorkers: 4})
+	Expect(t, err).ToBe(nil)
+	Expect(t, report.Files).ToBe(int64(8))
+	Expect(t, report.Dirs).ToBe(int64(6))
+	Expect(t, report.Bytes > 0).ToBe(true)
+	Expect(t, report.Duration > 0).ToBe(true)
+
+	When(t, "entries are skipped and errors are suppressed", func(t *testing.T) {
+		opt := Options{
+			Skip: func(info os.FileInfo, src, dest string) (bool, error) {
+				return strings.HasSuffix(src, "_skip"), nil
+			},
+			OnError: func(src, dest string, err error) error { return nil },
+		}
+		report, err := CopyWithReport("test/data/case06", "test/data.copy/case06_report", opt)
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Skipped).ToBe(int64(2))
+		Expect(t, len(report.Errors)).ToBe(0)
+
+		report, err = CopyWithReport("test/data/case17/non-existing", "test/data.copy/case17_report", opt)
+		Expect(t, err).ToBe(nil)
+		Expect(t, len(report.Errors)).ToBe(1)
+		Expect(t, os.IsNotExist(report.Errors[0].Err)).ToBe(true)
+
+		b, err := json.Marshal(report)
+		Expect(t, err).ToBe(nil)
+		Expect(t, strings.Contains(string(b), `"src":"test/data/case17/non-existing"`)).ToBe(true)
+	})
+}
+
+// prefixFS is a WritableFS which writes everything under root.
+type prefixFS struct {
+	root  string
+	chmod int
+}
+
+func (p *prefixFS) MkdirAll(path string, perm os.FileMode) error {
+	return OSFS.MkdirAll(filepath.Join(p.root, path), perm)
+}
+func (p *prefixFS) OpenFile(name string, flag int, perm os.FileMode) (WritableFile, error) {
+	return OSFS.OpenFile(filepath.Join(p.root, name), flag, perm)
+}
+func (p *prefixFS) Create(name string) (WritableFile, error) {
+	return OSFS.Create(filepath.Join(p.root, name))
+}
+func (p *prefixFS) Symlink(oldname, newname string) error {
+	return OSFS.Symlink(oldname, filepath.Join(p.root, newname))
+}
+func (p *prefixFS) Chmod(name string, mode os.FileMode) error {
+	p.chmod++
+	return OSFS.Chmod(filepath.Join(p.root, name), mode)
+}
+func (p *prefixFS) Chtimes(name string, atime, mtime time.Time) error {
+	return OSFS.Chtimes(filepath.Join(p.root, name), atime, mtime)
+}
+func (p *prefixFS) Chown(name string, uid, gid int) error {
+	return OSFS.Chown(filepath.Join(p.root, name), uid, gid)
+}
+func (p *prefixFS) Lstat(name string) (os.FileInfo, error) {
+	return OSFS.Lstat(filepath.Join(p.root, name))
+}
+func (p *prefixFS) RemoveAll(path string) error {
+	return OSFS.RemoveAll(filepath.Join(p.root, path))
+}
+func (p *prefixFS) Rename(oldpath, newpath string) error {
+	return OSFS.Rename(filepath.Join(p.root, oldpath), filepath.Join(p.root, newpath))
+}
+
+func TestOptions_DestFS(t *testing.T) {
+	destfs := &prefixFS{root: t.TempDir()}
+	opt := Options{DestFS: destfs, PreserveTimes: true, PermissionControl: AddPermission(0o200)}
+	err := Copy("test/data/case09", "case09", opt)
+	Expect(t, err).ToBe(nil)
+	b, err := os.ReadFile(filepath.Join(destfs.root, "case09/README.md"))
+	Expect(t, err).ToBe(nil)
+	Expect(t, strings.HasPrefix(string(b), "File with specific atime and mtime.")).ToBe(true)
+	Expect(t, destfs.chmod > 0).ToBe(true)
+	_, err = os.Stat("case09")
+	Expect(t, os.IsNotExist(err)).ToBe(true)
+
+	When(t, "OnDirExists is given", func(t *testing.T) {
+		opt := Options{DestFS: destfs, OnDirExists: func(src, dest string) DirExistsAction { return Replace }}
+		err := Copy("test/data/case10/dest", "case10/src", opt)
+		Expect(t, err).ToBe(nil)
+		err = Copy("test/data/case10", "case10", opt)
+		Expect(t, err).ToBe(nil)
+		_, err = os.Stat(filepath.Join(destfs.root, "case10/src/foo/text_eee"))
+		Expect(t, os.IsNotExist(err)).ToBe(true)
+		_, err = os.Stat(filepath.Join(destfs.root, "case10/src/bar/text_ccc"))
+		Expect(t, err).ToBe(nil)
+	})
+}
+
+func TestMemFS(t *testing.T) {
+	mem := NewMemFS()
+	opt := Options{DestFS: mem, PreserveTimes: true, PreserveOwner: true}
+	err := Copy("test/data/case09", "case09", opt)
+	Expect(t, err).ToBe(nil)
+	Expect(t, fstest.TestFS(mem, "case09/README.md", "case09/symlink")).ToBe(nil)
+
+	for _, entry := range []string{"", "README.md", "symlink"} {
+		orig, err := os.Lstat("test/data/case09/" + entry)
+		Expect(t, err).ToBe(nil)
+		copied, err := mem.Lstat("case09/" + entry)
+		Expect(t, err).ToBe(nil)
+		Expect(t, copied.Mode()).ToBe(orig.Mode())
+		Expect(t, copied.ModTime().Unix()).ToBe(orig.ModTime().Unix())
+	}
+	b, err := fs.ReadFile(mem, "case09/symlink")
+	Expect(t, err).ToBe(nil)
+	Expect(t, strings.HasPrefix(string(b), "File with specific atime and mtime.")).ToBe(true)
+
+	When(t, "MemFS is given as FS", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "case09")
+		err := Copy("case09", dest, Options{FS: mem, PreserveTimes: true})
+		Expect(t, err).ToBe(nil)
+		for _, entry := range []string{"", "README.md", "symlink"} {
+			orig, err := os.Lstat("test/data/case09/" + entry)
+			Expect(t, err).ToBe(nil)
+			copied, err := os.Lstat(filepath.Join(dest, entry))
+			Expect(t, err).ToBe(nil)
+			Expect(t, copied.Mode()).ToBe(orig.Mode())
+			Expect(t, copied.ModTime().Unix()).ToBe(orig.ModTime().Unix())
+		}
+	})
+
+	When(t, "embed.FS is copied into MemFS", func(t *testing.T) {
+		err := Copy("test/data/case18/assets", "assets", Options{FS: assets, DestFS: mem})
+		Expect(t, err).ToBe(nil)
+		b, err := fs.ReadFile(mem, "assets/README.md")
+		Expect(t, err).ToBe(nil)
+		Expect(t, len(b) > 0).ToBe(true)
+	})
+
+	When(t, "source has a named pipe", func(t *testing.T) {
+		if runtime.GOOS == "windows" || runtime.GOOS == "js" {
+			t.Skip("See https://github.com/otiai10/copy/issues/47")
+		}
+		err := Copy("test/data/case11", "case11", Options{DestFS: mem})
+		Expect(t, err).ToBe(nil)
+		info, err := mem.Lstat("case11/foo/bar")
+		Expect(t, err).ToBe(nil)
+		Expect(t, info.Mode()&os.ModeNamedPipe != 0).ToBe(true)
+	})
+
+	When(t, "dest is an absolute path and files are read back", func(t *testing.T) {
+		mem := NewMemFS()
+		for _, opt := range []Options{
+			{DestFS: mem, Verify: true},
+			{DestFS: mem, Update: UpdateContent},
+			{DestFS: mem, Resume: ResumeFull},
+		} {
+			err := Copy("test/data/case09", "/out", opt)
+			Expect(t, err).ToBe(nil)
+		}
+		b, err := fs.ReadFile(mem, "out/README.md")
+		Expect(t, err).ToBe(nil)
+		Expect(t, strings.HasPrefix(string(b), "File with specific atime and mtime.")).ToBe(true)
+	})
+}
+
+func TestOptions_Atomic(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "README.md")
+	Expect(t, os.WriteFile(dest, []byte("old content"), 0o644)).ToBe(nil)
+
+	err := Copy("test/data/case01/README.md", dest, Options{Atomic: true, Sync: true, PreserveTimes: true})
+	Expect(t, err).ToBe(nil)
+	b, err := os.ReadFile(dest)
+	Expect(t, err).ToBe(nil)
+	Expect(t, string(b)).ToBe("case01 - README.md")
+	files, err := os.ReadDir(dir)
+	Expect(t, err).ToBe(nil)
+	Expect(t, len(files)).ToBe(1)
+
+	When(t, "copying fails in the middle", func(t *testing.T) {
+		errInsideReader := errors.New("Something wrong inside reader")
+		opt := Options{Atomic: true, WrapReader: func(src io.Reader) io.Reader {
+			return io.MultiReader(io.LimitReader(src, 3), iotest.ErrReader(errInsideReader))
+		}}
+		err := Copy("test/data/case12/README.md", dest, opt)
+		Expect(t, err).ToBe(errInsideReader)
+		b, err := os.ReadFile(dest)
+		Expect(t, err).ToBe(nil)
+		Expect(t, string(b)).ToBe("case01 - README.md")
+		files, err := os.ReadDir(dir)
+		Expect(t, err).ToBe(nil)
+		Expect(t, len(files)).ToBe(1)
+	})
+
+	When(t, "DestFS is MemFS", func(t *testing.T) {
+		mem := NewMemFS()
+		err := Copy("test/data/case19", "case19", Options{Atomic: true, DestFS: mem, NumOfWorkers: 4})
+		Expect(t, err).ToBe(nil)
+		entries, err := fs.ReadDir(mem, "case19/foo_concurrent")
+		Expect(t, err).ToBe(nil)
+		for _, e := range entries {
+			Expect(t, strings.HasPrefix(e.Name(), ".")).ToBe(false)
+		}
+	})
+}
+
+func TestOptions_AtomicTree(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "case10")
+	err := Copy("test/data/case10/dest", dest)
+	Expect(t, err).ToBe(nil)
+
+	err = Copy("test/data/case10/src", dest, Options{AtomicTree: true})
+	Expect(t, err).ToBe(nil)
+	b, err := os.ReadFile(filepath.Join(dest, "foo/text_aaa"))
+	Expect(t, err).ToBe(nil)
+	Expect(t, string(b)).ToBe("This is text_aaa from src")
+	_, err = os.Stat(filepath.Join(dest, "foo/text_eee"))
+	Expect(t, os.IsNotExist(err)).ToBe(true)
+	files, err := os.ReadDir(dir)
+	Expect(t, err).ToBe(nil)
+	Expect(t, len(files)).ToBe(1)
+
+	When(t, "copying fails in the middle", func(t *testing.T) {
+		errInsideSkipFunc := errors.New("Something wrong inside Skip")
+		opt := Options{AtomicTree: true, Skip: func(info os.FileInfo, src, dest string) (bool, error) {
+			if strings.HasSuffix(src, "text_fff") {
+				return false, errInsideSkipFunc
+			}
+			return false, nil
+		}}
+		err := Copy("test/data/case10/dest", dest, opt)
+		Expect(t, err).ToBe(errInsideSkipFunc)
+		_, err = os.Stat(filepath.Join(dest, "bar/text_ccc"))
+		Expect(t, err).ToBe(nil)
+		files, err := os.ReadDir(dir)
+		Expect(t, err).ToBe(nil)
+		Expect(t, len(files)).ToBe(1)
+	})
+
+	When(t, "dest does not exist yet", func(t *testing.T) {
+		err := Copy("test/data/case10/src", filepath.Join(dir, "new"), Options{AtomicTree: true})
+		Expect(t, err).ToBe(nil)
+		_, err = os.Stat(filepath.Join(dir, "new/foo/text_aaa"))
+		Expect(t, err).ToBe(nil)
+	})
+
+	When(t, "DestFS is MemFS", func(t *testing.T) {
+		mem := NewMemFS()
+		opt := Options{AtomicTree: true, DestFS: mem}
+		Expect(t, Copy("test/data/case10/dest", "case10", opt)).ToBe(nil)
+		Expect(t, Copy("test/data/case10/src", "case10", opt)).ToBe(nil)
+		_, err := mem.Lstat("case10/foo/text_eee")
+		Expect(t, os.IsNotExist(err)).ToBe(true)
+		entries, err := mem.ReadDir(".")
+		Expect(t, err).ToBe(nil)
+		Expect(t, len(entries)).ToBe(1)
+	})
+}
+
+func TestOptions_Reflink(t *testing.T) {
+	for _, mode := range []ReflinkMode{ReflinkNever, ReflinkAuto} {
+		dest := filepath.Join(t.TempDir(), "README.md")
+		err := Copy("test/data/case01/README.md", dest, Options{Reflink: mode, Verify: true})
+		Expect(t, err).ToBe(nil)
+		b, err := os.ReadFile(dest)
+		Expect(t, err).ToBe(nil)
+		Expect(t, string(b)).ToBe("case01 - README.md")
+	}
+
+	When(t, "ReflinkAlways can not clone", func(t *testing.T) {
+		err := Copy("test/data/case01/README.md", "README.md", Options{Reflink: ReflinkAlways, DestFS: NewMemFS()})
+		Expect(t, err).TypeOf("*fs.PathError")
+		Expect(t, errors.Is(err, errReflinkUnsupported)).ToBe(true)
+	})
+}
+
+func TestOptions_OnFileExists(t *testing.T) {
+	src := t.TempDir()
+	Expect(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("new"), 0o644)).ToBe(nil)
+	setup := func(t *testing.T) string {
+		dest := t.TempDir()
+		Expect(t, os.WriteFile(filepath.Join(dest, "a.txt"), []byte("old"), 0o644)).ToBe(nil)
+		return dest
+	}
+	action := func(a FileExistsAction) Options {
+		return Options{OnFileExists: func(src, dest string, srcinfo, destinfo os.FileInfo) FileExistsAction {
+			return a
+		}}
+	}
+
+	When(t, "OnFileExists returns FileSkip", func(t *testing.T) {
+		dest := setup(t)
+		report, err := CopyWithReport(src, dest, action(FileSkip))
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Skipped).ToBe(int64(1))
+		Expect(t, mustRead(t, filepath.Join(dest, "a.txt"))).ToBe("old")
+	})
+
+	When(t, "OnFileExists returns FileError", func(t *testing.T) {
+		dest := setup(t)
+		err := Copy(src, dest, action(FileError))
+		Expect(t, errors.Is(err, ErrExists)).ToBe(true)
+		Expect(t, errors.Is(err, fs.ErrExist)).ToBe(true)
+		_, err = Plan(src, dest, action(FileError))
+		Expect(t, errors.Is(err, ErrExists)).ToBe(true)
+	})
+
+	When(t, "OnFileExists returns FileOverwriteIfNewer", func(t *testing.T) {
+		dest := setup(t)
+		past := time.Now().Add(-time.Hour)
+		Expect(t, os.Chtimes(filepath.Join(dest, "a.txt"), past, past)).ToBe(nil)
+		Expect(t, Copy(src, dest, action(FileOverwriteIfNewer))).ToBe(nil)
+		Expect(t, mustRead(t, filepath.Join(dest, "a.txt"))).ToBe("new")
+
+		dest = setup(t)
+		future := time.Now().Add(time.Hour)
+		Expect(t, os.Chtimes(filepath.Join(dest, "a.txt"), future, future)).ToBe(nil)
+		Expect(t, Copy(src, dest, action(FileOverwriteIfNewer))).ToBe(nil)
+		Expect(t, mustRead(t, filepath.Join(dest, "a.txt"))).ToBe("old")
+	})
+
+	When(t, "OnFileExists returns FileOverwriteIfDifferentSize", func(t *testing.T) {
+		dest := setup(t)
+		Expect(t, Copy(src, dest, action(FileOverwriteIfDifferentSize))).ToBe(nil)
+		Expect(t, mustRead(t, filepath.Join(dest, "a.txt"))).ToBe("old")
+		Expect(t, os.WriteFile(filepath.Join(src, "b.txt"), []byte("longer"), 0o644)).ToBe(nil)
+		Expect(t, os.WriteFile(filepath.Join(dest, "b.txt"), []byte("short"), 0o644)).ToBe(nil)
+		Expect(t, Copy(src, dest, action(FileOverwriteIfDifferentSize))).ToBe(nil)
+		Expect(t, mustRead(t, filepath.Join(dest, "b.txt"))).ToBe("longer")
+		Expect(t, os.Remove(filepath.Join(src, "b.txt"))).ToBe(nil)
+	})
+
+	When(t, "OnFileExists returns FileForceOverwrite for a read-only file", func(t *testing.T) {
+		dest := setup(t)
+		Expect(t, os.Chmod(filepath.Join(dest, "a.txt"), 0o444)).ToBe(nil)
+		Expect(t, Copy(src, dest, action(FileForceOverwrite))).ToBe(nil)
+		Expect(t, mustRead(t, filepath.Join(dest, "a.txt"))).ToBe("new")
+	})
+
+	When(t, "dest does not exist", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		Expect(t, Copy(src, dest, action(FileError))).ToBe(nil)
+		Expect(t, mustRead(t, filepath.Join(dest, "a.txt"))).ToBe("new")
+	})
+
+	When(t, "dest is created by someone else after Lstat", func(t *testing.T) {
+		dest := setup(t)
+		opt := action(FileSkip)
+		opt.DestFS = &racyFS{WritableFS: OSFS, name: "a.txt"}
+		report, err := CopyWithReport(src, dest, opt)
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Skipped).ToBe(int64(1))
+		Expect(t, mustRead(t, filepath.Join(dest, "a.txt"))).ToBe("old")
+
+		opt = action(FileError)
+		opt.DestFS = &racyFS{WritableFS: OSFS, name: "a.txt"}
+		err = Copy(src, dest, opt)
+		Expect(t, errors.Is(err, ErrExists)).ToBe(true)
+	})
+}
+
+func mustRead(t *testing.T, name string) string {
+	b, err := os.ReadFile(name)
+	Expect(t, err).ToBe(nil)
+	return string(b)
+}
+
+// racyFS is a WritableFS which reports the file of name does not exist
+// at the first Lstat, as if it was created by someone else just after that.
+type racyFS struct {
+	WritableFS
+	name string
+	done bool
+}
+
+func (r *racyFS) Lstat(name string) (os.FileInfo, error) {
+	if !r.done && filepath.Base(name) == r.name {
+		r.done = true
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	return r.WritableFS.Lstat(name)
+}
+
+func TestOptions_Backup(t *testing.T) {
+	src := t.TempDir()
+	Expect(t, os.WriteFile(filepath.Join(src, "config"), []byte("new"), 0o644)).ToBe(nil)
+
+	When(t, "Backup is BackupSimple", func(t *testing.T) {
+		dest := t.TempDir()
+		Expect(t, os.WriteFile(filepath.Join(dest, "config"), []byte("old"), 0o644)).ToBe(nil)
+		Expect(t, Copy(src, dest, Options{Backup: BackupSimple})).ToBe(nil)
+		Expect(t, mustRead(t, filepath.Join(dest, "config"))).ToBe("new")
+		Expect(t, mustRead(t, filepath.Join(dest, "config~"))).ToBe("old")
+	})
+
+	When(t, "Backup is BackupNumbered", func(t *testing.T) {
+		dest := t.TempDir()
+		Expect(t, os.WriteFile(filepath.Join(dest, "config"), []byte("old"), 0o644)).ToBe(nil)
+		Expect(t, Copy(src, dest, Options{Backup: BackupNumbered})).ToBe(nil)
+		Expect(t, Copy(src, dest, Options{Backup: BackupNumbered, Atomic: true})).ToBe(nil)
+		Expect(t, mustRead(t, filepath.Join(dest, "config.~1~"))).ToBe("old")
+		Expect(t, mustRead(t, filepath.Join(dest, "config.~2~"))).ToBe("new")
+
+		// dest is backed up only after the copy, not to disappear during it.
+		for _, opt := range []Options{{Atomic: true}, {Resume: ResumeFull}} {
+			opt.Backup = BackupSimple
+			opt.OnProgress = func(p Progress) {
+				Expect(t, mustRead(t, filepath.Join(dest, "config"))).ToBe("new")
+			}
+			Expect(t, Copy(src, dest, opt)).ToBe(nil)
+			Expect(t, mustRead(t, filepath.Join(dest, "config~"))).ToBe("new")
+		}
+
+		mem := NewMemFS()
+		Expect(t, Copy(src, "dest", Options{DestFS: mem})).ToBe(nil)
+		Expect(t, Copy(src, "dest", Options{DestFS: mem, Backup: BackupNumbered})).ToBe(nil)
+		_, err := mem.Lstat("dest/config.~1~")
+		Expect(t, err).ToBe(nil)
+	})
+
+	When(t, "BackupName is given", func(t *testing.T) {
+		dest := t.TempDir()
+		Expect(t, os.Symlink("somewhere", filepath.Join(dest, "link"))).ToBe(nil)
+		Expect(t, os.Symlink("config", filepath.Join(src, "link"))).ToBe(nil)
+		defer os.Remove(filepath.Join(src, "link"))
+		err := Copy(src, dest, Options{BackupName: func(dest string) (string, error) {
+			return dest + ".bak", nil
+		}})
+		Expect(t, err).ToBe(nil)
+		orig, err := os.Readlink(filepath.Join(dest, "link.bak"))
+		Expect(t, err).ToBe(nil)
+		Expect(t, orig).ToBe("somewhere")
+		_, err = os.Lstat(filepath.Join(dest, "config.bak"))
+		Expect(t, os.IsNotExist(err)).ToBe(true)
+	})
+
+	When(t, "OnDirExists returns Replace", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		Expect(t, os.MkdirAll(filepath.Join(dest, "sub"), os.ModePerm)).ToBe(nil)
+		Expect(t, os.MkdirAll(filepath.Join(src, "sub"), os.ModePerm)).ToBe(nil)
+		defer os.Remove(filepath.Join(src, "sub"))
+		Expect(t, os.WriteFile(filepath.Join(dest, "sub", "old.txt"), []byte("old"), 0o644)).ToBe(nil)
+		err := Copy(src, dest, Options{
+			Backup:      BackupSimple,
+			OnDirExists: func(src, dest string) DirExistsAction { return Replace },
+		})
+		Expect(t, err).ToBe(nil)
+		Expect(t, mustRead(t, filepath.Join(dest, "sub~", "old.txt"))).ToBe("old")
+		_, err = os.Lstat(filepath.Join(dest, "sub", "old.txt"))
+		Expect(t, os.IsNotExist(err)).ToBe(true)
+	})
+}
+
+func TestOptions_Update(t *testing.T) {
+	src := t.TempDir()
+	Expect(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("aaa"), 0o644)).ToBe(nil)
+	Expect(t, os.WriteFile(filepath.Join(src, "b.txt"), []byte("bbb"), 0o644)).ToBe(nil)
+
+	When(t, "Update is UpdateSizeAndTime", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		report, err := CopyWithReport(src, dest, Options{Update: UpdateSizeAndTime})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Files).ToBe(int64(2))
+
+		var last Progress
+		report, err = CopyWithReport(src, dest, Options{
+			Update:     UpdateSizeAndTime,
+			PreScan:    true,
+			OnProgress: func(p Progress) { last = p },
+		})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Files).ToBe(int64(0))
+		Expect(t, report.Skipped).ToBe(int64(2))
+		Expect(t, last.CopiedFiles).ToBe(last.TotalFiles)
+
+		past := time.Now().Add(-time.Hour)
+		Expect(t, os.Chtimes(filepath.Join(dest, "a.txt"), past, past)).ToBe(nil)
+		ops, err := Plan(src, dest, Options{Update: UpdateSizeAndTime})
+		Expect(t, err).ToBe(nil)
+		Expect(t, ops[1].Type).ToBe(OpCopyFile)
+		Expect(t, ops[2].Reason).ToBe("Update")
+		report, err = CopyWithReport(src, dest, Options{Update: UpdateSizeAndTime})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Files).ToBe(int64(1))
+	})
+
+	When(t, "UpdateTolerance is given", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		Expect(t, Copy(src, dest)).ToBe(nil)
+		info, err := os.Stat(filepath.Join(src, "a.txt"))
+		Expect(t, err).ToBe(nil)
+		near := info.ModTime().Add(time.Second)
+		for _, name := range []string{"a.txt", "b.txt"} {
+			Expect(t, os.Chtimes(filepath.Join(dest, name), near, near)).ToBe(nil)
+		}
+		report, err := CopyWithReport(src, dest, Options{Update: UpdateSizeAndTime, UpdateTolerance: 2 * time.Second})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Skipped).ToBe(int64(2))
+	})
+
+	When(t, "Update is UpdateContent", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		Expect(t, Copy(src, dest)).ToBe(nil)
+		Expect(t, os.WriteFile(filepath.Join(dest, "b.txt"), []byte("BBB"), 0o644)).ToBe(nil)
+		report, err := CopyWithReport(src, dest, Options{Update: UpdateContent})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Files).ToBe(int64(1))
+		Expect(t, report.Skipped).ToBe(int64(1))
+		b, err := os.ReadFile(filepath.Join(dest, "b.txt"))
+		Expect(t, err).ToBe(nil)
+		Expect(t, string(b)).ToBe("bbb")
+	})
+
+	When(t, "Hash is given for UpdateContent", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		Expect(t, Copy(src, dest)).ToBe(nil)
+		hashed := 0
+		opt := Options{Update: UpdateContent, Hash: func() hash.Hash { hashed++; return BLAKE2b256() }}
+		report, err := CopyWithReport(src, dest, opt)
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Skipped).ToBe(int64(2))
+		Expect(t, hashed).ToBe(4)
+	})
+
+	When(t, "DestFS can not be read for UpdateContent", func(t *testing.T) {
+		destfs := &prefixFS{root: t.TempDir()}
+		Expect(t, Copy(src, "dest", Options{DestFS: destfs})).ToBe(nil)
+		err := Copy(src, "dest", Options{DestFS: destfs, Update: UpdateContent})
+		Expect(t, errors.Is(err, errUpdateUnsupported)).ToBe(true)
+	})
+}
+
+func TestOptions_Mirror(t *testing.T) {
+	src := t.TempDir()
+	Expect(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0o644)).ToBe(nil)
+	Expect(t, os.WriteFile(filepath.Join(src, "keep.log"), []byte("log"), 0o644)).ToBe(nil)
+	setup := func(t *testing.T) string {
+		dest := t.TempDir()
+		Expect(t, os.MkdirAll(filepath.Join(dest, "stale", "dir"), os.ModePerm)).ToBe(nil)
+		for _, name := range []string{"a.txt", "stale.txt", "keep.log", "protected.log"} {
+			Expect(t, os.WriteFile(filepath.Join(dest, name), []byte("old"), 0o644)).ToBe(nil)
+		}
+		return dest
+	}
+	skip := func(info os.FileInfo, src, dest string) (bool, error) {
+		return strings.HasSuffix(src, ".log"), nil
+	}
+	exists := func(t *testing.T, name string) bool {
+		_, err := os.Lstat(name)
+		return err == nil
+	}
+
+	for _, mode := range []MirrorMode{MirrorDeleteAfter, MirrorDeleteBefore} {
+		When(t, "Mirror is given", func(t *testing.T) {
+			dest := setup(t)
+			ops, err := Plan(src, dest, Options{Mirror: mode, Skip: skip})
+			Expect(t, err).ToBe(nil)
+			deletes := 0
+			for _, op := range ops {
+				if op.Type == OpDelete {
+					deletes++
+				}
+			}
+			Expect(t, deletes).ToBe(2)
+
+			deleted := []string{}
+			report, err := CopyWithReport(src, dest, Options{Mirror: mode, Skip: skip, OnDelete: func(dest string, info os.FileInfo) {
+				deleted = append(deleted, filepath.Base(dest))
+			}})
+			Expect(t, err).ToBe(nil)
+			Expect(t, report.Deleted).ToBe(int64(2))
+			Expect(t, deleted).ToBe([]string{"stale", "stale.txt"})
+			Expect(t, exists(t, filepath.Join(dest, "a.txt"))).ToBe(true)
+			Expect(t, exists(t, filepath.Join(dest, "keep.log"))).ToBe(true)
+			Expect(t, exists(t, filepath.Join(dest, "protected.log"))).ToBe(true)
+		})
+	}
+
+	When(t, "MirrorDeleteSkipped is true", func(t *testing.T) {
+		dest := setup(t)
+		err := Copy(src, dest, Options{Mirror: MirrorDeleteAfter, Skip: skip, MirrorDeleteSkipped: true})
+		Expect(t, err).ToBe(nil)
+		Expect(t, exists(t, filepath.Join(dest, "a.txt"))).ToBe(true)
+		Expect(t, exists(t, filepath.Join(dest, "keep.log"))).ToBe(false)
+		Expect(t, exists(t, filepath.Join(dest, "protected.log"))).ToBe(false)
+	})
+
+	When(t, "Backup is given", func(t *testing.T) {
+		dest := setup(t)
+		for i := 0; i < 2; i++ {
+			err := Copy(src, dest, Options{Mirror: MirrorDeleteAfter, Skip: skip, Backup: BackupSimple})
+			Expect(t, err).ToBe(nil)
+			Expect(t, exists(t, filepath.Join(dest, "stale.txt"))).ToBe(false)
+			Expect(t, exists(t, filepath.Join(dest, "stale.txt~"))).ToBe(true)
+			Expect(t, exists(t, filepath.Join(dest, "a.txt~"))).ToBe(true)
+			Expect(t, exists(t, filepath.Join(dest, "a.txt~~"))).ToBe(false)
+			Expect(t, exists(t, filepath.Join(dest, "stale.txt~~"))).ToBe(false)
+		}
+	})
+
+	When(t, "OnDirExists returns Replace", func(t *testing.T) {
+		src := t.TempDir()
+		Expect(t, os.MkdirAll(filepath.Join(src, "sub"), os.ModePerm)).ToBe(nil)
+		Expect(t, os.WriteFile(filepath.Join(src, "sub", "a.txt"), []byte("a"), 0o644)).ToBe(nil)
+		dest := filepath.Join(t.TempDir(), "dest")
+		Expect(t, Copy(src, dest, Options{PreserveTimes: true})).ToBe(nil)
+		Expect(t, os.WriteFile(filepath.Join(dest, "sub", "stale"), []byte("old"), 0o644)).ToBe(nil)
+		opt := Options{
+			Update:      UpdateSizeAndTime,
+			Mirror:      MirrorDeleteAfter,
+			OnDirExists: func(src, dest string) DirExistsAction { return Replace },
+		}
+		ops, err := Plan(src, dest, opt)
+		Expect(t, err).ToBe(nil)
+		types := []OperationType{}
+		for _, op := range ops {
+			types = append(types, op.Type)
+		}
+		Expect(t, types).ToBe([]OperationType{OpMkdir, OpReplaceDir, OpCopyFile})
+		report, err := CopyWithReport(src, dest, opt)
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Files).ToBe(int64(1))
+		Expect(t, report.Deleted).ToBe(int64(0))
+	})
+
+	When(t, "BackupNumbered or BackupName is given", func(t *testing.T) {
+		dest := setup(t)
+		err := Copy(src, dest, Options{Mirror: MirrorDeleteAfter, Skip: skip, Backup: BackupNumbered})
+		Expect(t, err).ToBe(nil)
+		Expect(t, exists(t, filepath.Join(dest, "a.txt.~1~"))).ToBe(true)
+		Expect(t, exists(t, filepath.Join(dest, "a.txt.~1~.~1~"))).ToBe(false)
+
+		dest = setup(t)
+		bak := func(dest string) (string, error) { return dest + ".bak", nil }
+		err = Copy(src, dest, Options{Mirror: MirrorDeleteAfter, Skip: skip, BackupName: bak})
+		Expect(t, err).ToBe(nil)
+		Expect(t, exists(t, filepath.Join(dest, "a.txt.bak"))).ToBe(true)
+		Expect(t, exists(t, filepath.Join(dest, "a.txt.bak.bak"))).ToBe(false)
+	})
+}
+
+// corruptFS flips the first byte of every file written into it.
+type corruptFS struct{ *MemFS }
+
+type corruptFile struct {
+	WritableFile
+	written bool
+}
+
+func (c corruptFS) Create(name string) (WritableFile, error) {
+	f, err := c.MemFS.Create(name)
+	return &corruptFile{WritableFile: f}, err
+}
+
+func (f *corruptFile) Write(p []byte) (int, error) {
+	if !f.written && len(p) > 0 {
+		f.written = true
+		return f.WritableFile.Write(append([]byte{p[0] ^ 0xFF}, p[1:]...))
+	}
+	return f.WritableFile.Write(p)
+}
+
+func TestOptions_Verify(t *testing.T) {
+	for _, h := range []func() hash.Hash{nil, SHA256, BLAKE2b256, func() hash.Hash { return fnv.New64a() }} {
+		dest := filepath.Join(t.TempDir(), "dest")
+		err := Copy("test/data/case01", dest, Options{Verify: true, Hash: h, Sync: true, CopyFileRange: true})
+		Expect(t, err).ToBe(nil)
+		b, err := os.ReadFile(filepath.Join(dest, "README.md"))
+		Expect(t, err).ToBe(nil)
+		Expect(t, string(b)).ToBe("case01 - README.md")
+	}
+
+	When(t, "dest is corrupted", func(t *testing.T) {
+		err := Copy("test/data/case01/README.md", "README.md", Options{Verify: true, DestFS: corruptFS{NewMemFS()}})
+		Expect(t, errors.Is(err, ErrVerifyMismatch)).ToBe(true)
+
+		report, err := CopyWithReport("test/data/case01", "dest", Options{
+			Verify:  true,
+			DestFS:  corruptFS{NewMemFS()},
+			OnError: func(src, dest string, err error) error { return nil },
+		})
+		Expect(t, err).ToBe(nil)
+		Expect(t, len(report.Errors) > 0).ToBe(true)
+		Expect(t, errors.Is(report.Errors[0].Err, ErrVerifyMismatch)).ToBe(true)
+	})
+}
+
+func TestOptions_Manifest(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "dest")
+	manifest := &Manifest{}
+	err := Copy("test/data/case03", dest, Options{Manifest: manifest, NumOfWorkers: 4})
+	Expect(t, err).ToBe(nil)
+	Expect(t, len(manifest.Entries) > 0).ToBe(true)
+	for i, entry := range manifest.Entries {
+		Expect(t, strings.HasPrefix(entry.Path, "/")).ToBe(false)
+		if i > 0 {
+			Expect(t, manifest.Entries[i-1].Path < entry.Path).ToBe(true)
+		}
+	}
+	Expect(t, VerifyManifest(dest, manifest)).ToBe(nil)
+
+	b, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(manifest.Entries[0].Path)))
+	Expect(t, err).ToBe(nil)
+	sum := sha256.Sum256(b)
+	sums := &bytes.Buffer{}
+	Expect(t, manifest.WriteSums(sums)).ToBe(nil)
+	Expect(t, strings.HasPrefix(sums.String(), hex.EncodeToString(sum[:])+"  "+manifest.Entries[0].Path+"\n")).ToBe(true)
+
+	When(t, "the manifest is read from JSON", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		Expect(t, manifest.WriteJSON(buf)).ToBe(nil)
+		loaded := &Manifest{}
+		Expect(t, json.Unmarshal(buf.Bytes(), loaded)).ToBe(nil)
+		Expect(t, VerifyManifest(dest, loaded)).ToBe(nil)
+	})
+
+	When(t, "a file is modified", func(t *testing.T) {
+		name := filepath.Join(dest, filepath.FromSlash(manifest.Entries[0].Path))
+		info, err := os.Stat(name)
+		Expect(t, err).ToBe(nil)
+		Expect(t, os.WriteFile(name, bytes.Repeat([]byte("x"), len(b)), info.Mode())).ToBe(nil)
+		Expect(t, os.Chtimes(name, info.ModTime(), info.ModTime())).ToBe(nil)
+		err = VerifyManifest(dest, manifest)
+		Expect(t, errors.Is(err, ErrVerifyMismatch)).ToBe(true)
+		Expect(t, strings.HasSuffix(err.Error(), "digest")).ToBe(true)
+	})
+
+	When(t, "AtomicTree and Atomic are given", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		m := &Manifest{}
+		err := Copy("test/data/case03", dest, Options{Manifest: m, Atomic: true, AtomicTree: true, Hash: BLAKE2b256})
+		Expect(t, err).ToBe(nil)
+		Expect(t, len(m.Entries)).ToBe(len(manifest.Entries))
+		Expect(t, m.Entries[0].Path).ToBe(manifest.Entries[0].Path)
+		Expect(t, VerifyManifest(dest, m)).ToBe(nil)
+
+		buf := &bytes.Buffer{}
+		Expect(t, m.WriteJSON(buf)).ToBe(nil)
+		loaded := &Manifest{}
+		Expect(t, json.Unmarshal(buf.Bytes(), loaded)).ToBe(nil)
+		Expect(t, loaded.Algorithm).ToBe("blake2b-256")
+		Expect(t, VerifyManifest(dest, loaded)).ToBe(nil)
+	})
+
+	When(t, "the manifest of a custom Hash is read from JSON", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		m := &Manifest{}
+		err := Copy("test/data/case03", dest, Options{Manifest: m, Hash: func() hash.Hash { return fnv.New64a() }})
+		Expect(t, err).ToBe(nil)
+		Expect(t, VerifyManifest(dest, m)).ToBe(nil)
+		loaded := &Manifest{Entries: m.Entries, Algorithm: m.Algorithm}
+		Expect(t, loaded.Algorithm).ToBe("custom")
+		err = VerifyManifest(dest, loaded)
+		Expect(t, errors.Is(err, errUnknownAlgorithm)).ToBe(true)
+	})
+
+	When(t, "Manifest.Hash is given", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		m := &Manifest{Hash: BLAKE2b256}
+		Expect(t, Copy("test/data/case03", dest, Options{Manifest: m})).ToBe(nil)
+		Expect(t, m.Algorithm).ToBe("blake2b-256")
+		loaded := &Manifest{Entries: m.Entries, Algorithm: m.Algorithm}
+		Expect(t, VerifyManifest(dest, loaded)).ToBe(nil)
+
+		err := Copy("test/data/case03", dest, Options{Manifest: &Manifest{Hash: BLAKE2b256}, Hash: SHA256})
+		Expect(t, errors.Is(err, errHashMismatch)).ToBe(true)
+	})
+
+	When(t, "a single file is renamed by Transform", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "a.txt")
+		Expect(t, os.WriteFile(src, []byte("single"), 0o644)).ToBe(nil)
+		dest := filepath.Join(t.TempDir(), "a.txt")
+		m := &Manifest{}
+		Expect(t, Copy(src, dest, Options{Manifest: m, Transform: GzipCompress})).ToBe(nil)
+		Expect(t, len(m.Entries)).ToBe(1)
+		Expect(t, m.Entries[0].Path).ToBe("a.txt.gz")
+		Expect(t, VerifyManifest(filepath.Dir(dest), m)).ToBe(nil)
+	})
+}
+
+func TestFilter(t *testing.T) {
+	src := t.TempDir()
+	for name, size := range map[string]int{
+		"main.go":              10,
+		"README.md":            10,
+		"pkg/a/a.go":           10,
+		"pkg/a/a_test.go":      10,
+		"pkg/a/testdata/x.bin": 2048,
+		"vendor/lib/lib.go":    10,
+	} {
+		Expect(t, os.MkdirAll(filepath.Join(src, filepath.Dir(name)), os.ModePerm)).ToBe(nil)
+		Expect(t, os.WriteFile(filepath.Join(src, name), bytes.Repeat([]byte("x"), size), 0o644)).ToBe(nil)
+	}
+	copied := func(t *testing.T, f *Filter) []string {
+		dest := t.TempDir()
+		Expect(t, Copy(src, dest, Options{Skip: f.Skip})).ToBe(nil)
+		return filesUnder(t, dest)
+	}
+
+	When(t, "include and exclude patterns are given", func(t *testing.T) {
+		f := NewFilter(src, "!vendor/**", "!**/*_test.go", "**/*.go")
+		Expect(t, copied(t, f)).ToBe([]string{"main.go", "pkg/a/a.go"})
+	})
+
+	When(t, "only exclude patterns are given", func(t *testing.T) {
+		f := NewFilter(src, "!vendor/**", "!pkg/*/testdata")
+		Expect(t, copied(t, f)).ToBe([]string{"README.md", "main.go", "pkg/a/a.go", "pkg/a/a_test.go"})
+	})
+
+	When(t, "predicates are given", func(t *testing.T) {
+		f := &Filter{Root: src, Rules: []Rule{
+			{Exclude: true, Match: LargerThan(1024)},
+			{Pattern: "vendor/**", Exclude: true, Match: OfType(os.ModeDir)},
+			{Pattern: "**/*.md", Exclude: true, Match: NewerThan(time.Hour)},
+		}}
+		Expect(t, copied(t, f)).ToBe([]string{"main.go", "pkg/a/a.go", "pkg/a/a_test.go"})
+	})
+
+	When(t, "patterns are matched", func(t *testing.T) {
+		Expect(t, matchGlob("**/*.go", "a.go")).ToBe(true)
+		Expect(t, matchGlob("**/*.go", "a/b/c.go")).ToBe(true)
+		Expect(t, matchGlob("*.go", "a/b.go")).ToBe(false)
+		Expect(t, matchGlob("a/**/c", "a/c")).ToBe(true)
+		Expect(t, matchGlob("a/**/c", "a/b/b/c")).ToBe(true)
+		Expect(t, matchGlob("a/**", "a")).ToBe(true)
+		Expect(t, matchGlob("a/?", "a/bc")).ToBe(false)
+		Expect(t, HasMode(0o644)(mustStat(t, filepath.Join(src, "main.go")))).ToBe(true)
+		Expect(t, OlderThan(time.Hour)(mustStat(t, filepath.Join(src, "main.go")))).ToBe(false)
+	})
+}
+
+func mustStat(t *testing.T, name string) os.FileInfo {
+	info, err := os.Stat(name)
+	Expect(t, err).ToBe(nil)
+	return info
+}
+
+// filesUnder lists the slash-separated paths of the files under dir.
+func filesUnder(t *testing.T, dir string) []string {
+	names := []string{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, p)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	Expect(t, err).ToBe(nil)
+	return names
+}
+
+func TestIgnore(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		".gitignore":                   "# comment\nnode_modules/\n*.log\n!keep.log\n/build\ndocs/*.tmp\n",
+		"main.go":                      "",
+		"debug.log":                    "",
+		"keep.log":                     "",
+		"build/out":                    "",
+		"sub/build/out":                "",
+		"sub/.ignore":                  "*.go\n",
+		"sub/sub.go":                   "",
+		"sub/node_modules/x/index.js":  "",
+		"sub/.myignore":                "!*.log\n",
+		"sub/sub.log":                  "",
+		"docs/a.tmp":                   "",
+		"docs/deep/b.tmp":              "",
+		"node_modules":                 "",
+		"vendor/node_modules/y/a.json": "",
+	}
+	for name, content := range files {
+		Expect(t, os.MkdirAll(filepath.Join(src, filepath.Dir(name)), os.ModePerm)).ToBe(nil)
+		Expect(t, os.WriteFile(filepath.Join(src, name), []byte(content), 0o644)).ToBe(nil)
+	}
+	copied := func(t *testing.T, ig *Ignore) []string {
+		dest := t.TempDir()
+		visited := []string{}
+		skip := func(info os.FileInfo, src, dest string) (bool, error) {
+			visited = append(visited, src)
+			return ig.Skip(info, src, dest)
+		}
+		Expect(t, Copy(src, dest, Options{Skip: skip})).ToBe(nil)
+		for _, v := range visited {
+			Expect(t, strings.Contains(v, "node_modules"+string(filepath.Separator))).ToBe(false)
+		}
+		return filesUnder(t, dest)
+	}
+
+	When(t, ".gitignore and .ignore are read", func(t *testing.T) {
+		Expect(t, copied(t, NewIgnore(src))).ToBe([]string{
+			".gitignore", "docs/deep/b.tmp", "keep.log", "main.go", "node_modules",
+			"sub/.ignore", "sub/.myignore", "sub/build/out",
+		})
+	})
+
+	When(t, "the custom ignore file is given", func(t *testing.T) {
+		Expect(t, copied(t, NewIgnore(src, ".gitignore", ".myignore"))).ToBe([]string{
+			".gitignore", "docs/deep/b.tmp", "keep.log", "main.go", "node_modules",
+			"sub/.ignore", "sub/.myignore", "sub/build/out", "sub/sub.go", "sub/sub.log",
+		})
+	})
+}
+
+func TestOptions_Resume(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "dataset.bin")
+	data := make([]byte, 3*1024*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	Expect(t, os.WriteFile(src, data, 0o644)).ToBe(nil)
+	interrupted := errors.New("interrupted")
+	interrupt := func(r io.Reader) io.Reader {
+		return io.MultiReader(io.LimitReader(r, 2*1024*1024), iotest.ErrReader(interrupted))
+	}
+
+	for _, mode := range []ResumeMode{ResumeTail, ResumeFull} {
+		When(t, "the copy is interrupted", func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "dataset.bin")
+			err := Copy(src, dest, Options{Resume: mode, WrapReader: interrupt})
+			Expect(t, errors.Is(err, interrupted)).ToBe(true)
+			_, err = os.Stat(dest)
+			Expect(t, os.IsNotExist(err)).ToBe(true)
+			info, err := os.Stat(partialName(dest))
+			Expect(t, err).ToBe(nil)
+			Expect(t, info.Size()).ToBe(int64(2 * 1024 * 1024))
+
+			manifest := &Manifest{}
+			report, err := CopyWithReport(src, dest, Options{Resume: mode, Verify: true, Manifest: manifest})
+			Expect(t, err).ToBe(nil)
+			Expect(t, report.Resumed).ToBe(int64(1))
+			Expect(t, report.Bytes).ToBe(int64(len(data)))
+			b, err := os.ReadFile(dest)
+			Expect(t, err).ToBe(nil)
+			Expect(t, bytes.Equal(b, data)).ToBe(true)
+			sum := sha256.Sum256(data)
+			Expect(t, manifest.Entries[0].Digest).ToBe(hex.EncodeToString(sum[:]))
+			_, err = os.Stat(partialName(dest))
+			Expect(t, os.IsNotExist(err)).ToBe(true)
+		})
+	}
+
+	When(t, "src is read-only", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "readonly.bin")
+		Expect(t, os.WriteFile(src, data, 0o444)).ToBe(nil)
+		dest := filepath.Join(t.TempDir(), "readonly.bin")
+		err := Copy(src, dest, Options{Resume: ResumeTail, WrapReader: interrupt})
+		Expect(t, errors.Is(err, interrupted)).ToBe(true)
+		Expect(t, mustStat(t, partialName(dest)).Mode()&0o200).ToBe(os.FileMode(0o200))
+		report, err := CopyWithReport(src, dest, Options{Resume: ResumeTail})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Resumed).ToBe(int64(1))
+		Expect(t, mustStat(t, dest).Mode().Perm()).ToBe(os.FileMode(0o444))
+	})
+
+	When(t, "the partial file is broken", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dataset.bin")
+		broken := append([]byte(nil), data[:2*1024*1024]...)
+		broken[len(broken)-1] ^= 0xFF
+		Expect(t, os.WriteFile(partialName(dest), broken, 0o644)).ToBe(nil)
+		report, err := CopyWithReport(src, dest, Options{Resume: ResumeTail})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Resumed).ToBe(int64(0))
+		b, err := os.ReadFile(dest)
+		Expect(t, err).ToBe(nil)
+		Expect(t, bytes.Equal(b, data)).ToBe(true)
+	})
+
+	When(t, "DestFS is MemFS", func(t *testing.T) {
+		mem := NewMemFS()
+		err := Copy(src, "dataset.bin", Options{Resume: ResumeFull, WrapReader: interrupt, DestFS: mem})
+		Expect(t, errors.Is(err, interrupted)).ToBe(true)
+		report, err := CopyWithReport(src, "dataset.bin", Options{Resume: ResumeFull, DestFS: mem})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Resumed).ToBe(int64(1))
+		b, err := fs.ReadFile(mem, "dataset.bin")
+		Expect(t, err).ToBe(nil)
+		Expect(t, bytes.Equal(b, data)).ToBe(true)
+	})
+}
+
+func TestOptions_RateLimit(t *testing.T) {
+	src := t.TempDir()
+	for i := 0; i < 4; i++ {
+		Expect(t, os.WriteFile(filepath.Join(src, fmt.Sprintf("%d.bin", i)), make([]byte, 64*1024), 0o644)).ToBe(nil)
+	}
+
+	When(t, "RateLimit is shared by workers", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		begin := time.Now()
+		// 256KiB in total at 128KiB/s, where the first 128KiB is the burst.
+		err := Copy(src, dest, Options{RateLimit: 128 * 1024, NumOfWorkers: 4})
+		Expect(t, err).ToBe(nil)
+		Expect(t, time.Since(begin) >= 900*time.Millisecond).ToBe(true)
+	})
+
+	When(t, "RateLimitOps is given", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		begin := time.Now()
+		// 5 entries at 4 entries/s, where the first 4 are the burst.
+		err := Copy(src, dest, Options{RateLimitOps: 4, NumOfWorkers: 4})
+		Expect(t, err).ToBe(nil)
+		Expect(t, time.Since(begin) >= 200*time.Millisecond).ToBe(true)
+	})
+
+	When(t, "the context is canceled while waiting", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err := CopyContext(ctx, src, filepath.Join(t.TempDir(), "dest"), Options{RateLimit: 1024})
+		Expect(t, errors.Is(err, context.DeadlineExceeded)).ToBe(true)
+	})
+}
+
+func TestOptions_Transform(t *testing.T) {
+	src := t.TempDir()
+	Expect(t, os.WriteFile(filepath.Join(src, "app.log"), []byte(strings.Repeat("log line\r\n", 100)), 0o644)).ToBe(nil)
+	Expect(t, os.WriteFile(filepath.Join(src, "README.md"), []byte("readme\r\n"), 0o644)).ToBe(nil)
+	logs := func(src, dest string, info os.FileInfo) (*Transform, error) {
+		if strings.HasSuffix(src, ".log") {
+			return GzipCompress(src, dest, info)
+		}
+		return nil, nil
+	}
+
+	When(t, "GzipCompress and GzipDecompress are given", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		manifest := &Manifest{}
+		err := Copy(src, dest, Options{Transform: logs, Verify: true, Manifest: manifest, Mirror: MirrorDeleteAfter})
+		Expect(t, err).ToBe(nil)
+		Expect(t, VerifyManifest(dest, manifest)).ToBe(nil)
+		_, err = os.Stat(filepath.Join(dest, "app.log"))
+		Expect(t, os.IsNotExist(err)).ToBe(true)
+		f, err := os.Open(filepath.Join(dest, "app.log.gz"))
+		Expect(t, err).ToBe(nil)
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		Expect(t, err).ToBe(nil)
+		b, err := io.ReadAll(r)
+		Expect(t, err).ToBe(nil)
+		Expect(t, string(b)).ToBe(strings.Repeat("log line\r\n", 100))
+		b, err = os.ReadFile(filepath.Join(dest, "README.md"))
+		Expect(t, err).ToBe(nil)
+		Expect(t, string(b)).ToBe("readme\r\n")
+
+		ops, err := Plan(src, dest, Options{Transform: logs, Mirror: MirrorDeleteAfter})
+		Expect(t, err).ToBe(nil)
+		for _, op := range ops {
+			Expect(t, op.Type == OpDelete).ToBe(false)
+		}
+
+		back := filepath.Join(t.TempDir(), "back")
+		err = Copy(dest, back, Options{Transform: GzipDecompress})
+		Expect(t, err).ToBe(nil)
+		Expect(t, mustRead(t, filepath.Join(back, "app.log"))).ToBe(strings.Repeat("log line\r\n", 100))
+		Expect(t, mustRead(t, filepath.Join(back, "README.md"))).ToBe("readme\r\n")
+	})
+
+	When(t, "the bytes are counted", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		var last Progress
+		report, err := CopyWithReport(filepath.Join(src, "app.log"), dest, Options{
+			Transform:  GzipCompress,
+			OnProgress: func(p Progress) { last = p },
+			PreScan:    true,
+		})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Bytes).ToBe(mustStat(t, dest+".gz").Size())
+		Expect(t, last.CopiedBytes).ToBe(int64(len("log line\r\n") * 100))
+		Expect(t, last.CopiedBytes).ToBe(last.TotalBytes)
+	})
+
+	When(t, "Update is given", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "a.txt")
+		Expect(t, os.WriteFile(src, []byte("hello"), 0o644)).ToBe(nil)
+		dest := filepath.Join(t.TempDir(), "a.txt")
+		Expect(t, os.WriteFile(dest, []byte("xxxxx"), 0o644)).ToBe(nil)
+		mtime := mustStat(t, src).ModTime()
+		Expect(t, os.Chtimes(dest, mtime, mtime)).ToBe(nil)
+		upper := func(src, dest string, info os.FileInfo) (*Transform, error) {
+			return &Transform{Reader: func(r io.Reader) (io.Reader, error) {
+				b, err := io.ReadAll(r)
+				return bytes.NewReader(bytes.ToUpper(b)), err
+			}}, nil
+		}
+		Expect(t, Copy(src, dest, Options{Transform: upper, Update: UpdateSizeAndTime})).ToBe(nil)
+		Expect(t, mustRead(t, dest)).ToBe("HELLO")
+	})
+
+	When(t, "ZlibCompress and ZlibDecompress are given", func(t *testing.T) {
+		compressed := filepath.Join(t.TempDir(), "app.log.z")
+		Expect(t, Copy(filepath.Join(src, "app.log"), compressed, Options{Transform: ZlibCompress})).ToBe(nil)
+		back := filepath.Join(t.TempDir(), "app.log")
+		Expect(t, Copy(compressed, back, Options{Transform: ZlibDecompress})).ToBe(nil)
+		b, err := os.ReadFile(back)
+		Expect(t, err).ToBe(nil)
+		Expect(t, string(b)).ToBe(strings.Repeat("log line\r\n", 100))
+	})
+
+	When(t, "NormalizeCRLF is given", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		Expect(t, Copy(src, dest, Options{Transform: NormalizeCRLF, CopyBufferSize: 3})).ToBe(nil)
+		b, err := os.ReadFile(filepath.Join(dest, "app.log"))
+		Expect(t, err).ToBe(nil)
+		Expect(t, string(b)).ToBe(strings.Repeat("log line\n", 100))
+		b, err = io.ReadAll(crlfReader{bufio.NewReader(strings.NewReader("a\r\rb\r"))})
+		Expect(t, err).ToBe(nil)
+		Expect(t, string(b)).ToBe("a\r\rb\r")
+	})
+}
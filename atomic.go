@@ -0,0 +1,102 @@
+package copy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+var tmpCounter uint64
+
+// tempName finds a hidden name next to dest which does not exist yet.
+func tempName(destfs WritableFS, dest, suffix string) (string, error) {
+	dir, base := filepath.Split(dest)
+	for {
+		name := filepath.Join(dir, fmt.Sprintf(".%s.%d-%d.%s", base, os.Getpid(), atomic.AddUint64(&tmpCounter, 1), suffix))
+		if _, err := destfs.Lstat(name); os.IsNotExist(err) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
+// createTemp creates a hidden temporary file next to dest,
+// so that it can be renamed over dest on the same filesystem.
+func createTemp(destfs WritableFS, dest string) (WritableFile, string, error) {
+	for {
+		tmp, err := tempName(destfs, dest, "tmp")
+		if err != nil {
+			return nil, "", err
+		}
+		f, err := destfs.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, tmp, err
+	}
+}
+
+// commitTemp renames tmp over dest if everything succeeded,
+// otherwise removes tmp not to leave any garbage.
+// dest is backed up only right before it's replaced, so that it does not disappear during the copy.
+func commitTemp(tmp, dest string, opt Options, reported *error) {
+	if *reported == nil {
+		*reported = backup(dest, opt)
+	}
+	if *reported == nil {
+		*reported = opt.DestFS.Rename(tmp, dest)
+	}
+	if *reported != nil {
+		opt.DestFS.RemoveAll(tmp)
+	}
+}
+
+// copyTree copies the directory src into a staging directory next to dest,
+// and swaps it with dest only after everything succeeded.
+func copyTree(src, dest string, info os.FileInfo, opt Options) error {
+	staging, err := tempName(opt.DestFS, dest, "staging")
+	if err != nil {
+		return onError(src, dest, err, opt)
+	}
+	// The staging directory is the root of what is copied, such as for Manifest.
+	opt.intent.dest = staging
+	if err := switchboard(src, staging, info, opt); err != nil {
+		opt.DestFS.RemoveAll(staging)
+		return err
+	}
+	return onError(src, dest, swapTree(opt.DestFS, staging, dest), opt)
+}
+
+// swapTree puts staging in place of dest, and removes the old dest.
+// If WritableFS can exchange them atomically, dest never disappears,
+// otherwise dest is renamed aside for a moment.
+func swapTree(destfs WritableFS, staging, dest string) error {
+	if _, err := destfs.Lstat(dest); os.IsNotExist(err) {
+		return destfs.Rename(staging, dest)
+	} else if err != nil {
+		destfs.RemoveAll(staging)
+		return err
+	}
+	if xfs, ok := destfs.(exchangeFS); ok {
+		if err := xfs.Exchange(staging, dest); err == nil {
+			return destfs.RemoveAll(staging)
+		}
+	}
+	old, err := tempName(destfs, dest, "old")
+	if err != nil {
+		destfs.RemoveAll(staging)
+		return err
+	}
+	if err := destfs.Rename(dest, old); err != nil {
+		destfs.RemoveAll(staging)
+		return err
+	}
+	if err := destfs.Rename(staging, dest); err != nil {
+		destfs.Rename(old, dest)
+		destfs.RemoveAll(staging)
+		return err
+	}
+	return destfs.RemoveAll(old)
+}
@@ -0,0 +1,136 @@
+package copy
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BackupMode represents how to back up dest before it's overwritten, as "cp --backup" does.
+type BackupMode int
+
+const (
+	// BackupNone does not back up anything (default behavior).
+	BackupNone BackupMode = iota
+	// BackupSimple renames dest to dest + BackupSuffix, e.g. "config~".
+	BackupSimple
+	// BackupNumbered renames dest to the next numbered name, e.g. "config.~2~".
+	BackupNumbered
+)
+
+// readDirFS is WritableFS which can read directories, such as MemFS.
+type readDirFS interface {
+	ReadDir(name string) ([]fs.DirEntry, error)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+
+// backups reports whether dest should be backed up before it's overwritten.
+func backups(opt Options) bool {
+	return opt.Backup != BackupNone || opt.BackupName != nil
+}
+
+// backup renames dest to its backup name, if dest exists.
+func backup(dest string, opt Options) error {
+	if !backups(opt) {
+		return nil
+	}
+	if _, err := opt.DestFS.Lstat(dest); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	name, err := backupName(dest, opt)
+	if err != nil {
+		return err
+	}
+	if _, err := opt.DestFS.Lstat(name); err == nil {
+		if err := opt.DestFS.RemoveAll(name); err != nil {
+			return err
+		}
+	}
+	return opt.DestFS.Rename(dest, name)
+}
+
+// backupName returns the backup name of dest, regarding Backup and BackupName.
+func backupName(dest string, opt Options) (string, error) {
+	if opt.BackupName != nil {
+		return opt.BackupName(dest)
+	}
+	if opt.Backup == BackupSimple {
+		if opt.BackupSuffix == "" {
+			return dest + "~", nil
+		}
+		return dest + opt.BackupSuffix, nil
+	}
+	n, err := lastBackupNumber(dest, opt)
+	if err != nil {
+		return "", err
+	}
+	return dest + ".~" + strconv.Itoa(n+1) + "~", nil
+}
+
+// lastBackupNumber finds the largest N of "dest.~N~".
+// If DestFS can not read directories, it's the last one of the consecutive numbers from 1.
+func lastBackupNumber(dest string, opt Options) (int, error) {
+	destfs, ok := opt.DestFS.(readDirFS)
+	if !ok {
+		n := 0
+		for {
+			if _, err := opt.DestFS.Lstat(dest + ".~" + strconv.Itoa(n+1) + "~"); err != nil {
+				return n, nil
+			}
+			n++
+		}
+	}
+	entries, err := destfs.ReadDir(filepath.Dir(dest))
+	if err != nil {
+		return 0, err
+	}
+	prefix := filepath.Base(dest) + ".~"
+	last := 0
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		if n := backupNumber(e.Name()); n > last {
+			last = n
+		}
+	}
+	return last, nil
+}
+
+// backupNumber returns N of the name "*.~N~", or 0 if it's not numbered so.
+func backupNumber(name string) int {
+	i := strings.LastIndex(name, ".~")
+	if i < 1 || !strings.HasSuffix(name, "~") {
+		return 0
+	}
+	n, err := strconv.Atoi(name[i+2 : len(name)-1])
+	if err != nil || n < 1 {
+		return 0
+	}
+	return n
+}
+
+// isBackup reports whether the name looks like a backup made by Backup,
+// so that Mirror does not take it as extraneous and back it up again.
+// Backups by BackupName can not be told by the name, see extraneous.
+func isBackup(name string, opt Options) bool {
+	switch {
+	case opt.BackupName != nil:
+		return false
+	case opt.Backup == BackupSimple:
+		suffix := opt.BackupSuffix
+		if suffix == "" {
+			suffix = "~"
+		}
+		return len(name) > len(suffix) && strings.HasSuffix(name, suffix)
+	case opt.Backup == BackupNumbered:
+		return backupNumber(name) > 0
+	default:
+		return false
+	}
+}
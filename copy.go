@@ -2,6 +2,8 @@ package copy
 
 import (
 	"context"
+	"errors"
+	"hash"
 	"io"
 	"io/fs"
 	"os"
@@ -20,30 +22,66 @@ type timespec struct {
 
 // Copy copies src to dest, doesn't matter if src is a directory or a file.
 func Copy(src, dest string, opts ...Options) error {
+	return CopyContext(context.Background(), src, dest, opts...)
+}
+
+// CopyContext is the same as Copy, but it stops copying when ctx is done.
+// Entries not yet started are not copied, and a file being copied is
+// interrupted in the middle. The returned error wraps ctx.Err()
+// with the path which was in progress, and it does not go to OnError.
+func CopyContext(ctx context.Context, src, dest string, opts ...Options) error {
 	opt := assureOptions(src, dest, opts...)
+	opt.intent.ctx = ctx
+	return start(src, dest, opt)
+}
+
+// start copies src to dest with the assured options.
+func start(src, dest string, opt Options) error {
+	if err := opt.intent.ctx.Err(); err != nil {
+		return canceled(src, err)
+	}
+	var err error
+	if opt.Hash, err = opt.Manifest.hashFor(opt.Hash); err != nil {
+		return err
+	}
 	if opt.NumOfWorkers > 1 {
 		opt.intent.sem = semaphore.NewWeighted(opt.NumOfWorkers)
-		opt.intent.ctx = context.Background()
 	}
-	if opt.FS != nil {
-		info, err := fs.Stat(opt.FS, src)
-		if err != nil {
-			return onError(src, dest, err, opt)
-		}
-		return switchboard(src, dest, info, opt)
+	opt.intent.progress = newProgress(opt)
+	opt.intent.hardlinks = newHardlinks(opt)
+	opt.intent.bytes = newBucket(opt.RateLimit)
+	opt.intent.ops = newBucket(opt.RateLimitOps)
+	var info os.FileInfo
+	if _, ok := opt.FS.(readlinkFS); ok {
+		info, err = lstat(src, opt)
+	} else if opt.FS != nil {
+		info, err = fs.Stat(opt.FS, src)
+	} else {
+		info, err = os.Lstat(src)
 	}
-	info, err := os.Lstat(src)
 	if err != nil {
 		return onError(src, dest, err, opt)
 	}
+	if opt.PreScan {
+		prescan(src, dest, info, opt)
+	}
+	defer opt.Manifest.sort()
+	if opt.AtomicTree && info.IsDir() {
+		return copyTree(src, dest, info, opt)
+	}
 	return switchboard(src, dest, info, opt)
 }
 
 // switchboard switches proper copy functions regarding file type, etc...
 // If there would be anything else here, add a case to this switchboard.
 func switchboard(src, dest string, info os.FileInfo, opt Options) (err error) {
-	if info.Mode()&os.ModeDevice != 0 && !opt.Specials {
+	action := onSpecial(src, info, opt)
+	switch action {
+	case SpecialSkip:
+		opt.intent.report.skipped()
 		return onError(src, dest, err, opt)
+	case SpecialError:
+		return onError(src, dest, &fs.PathError{Op: "copy", Path: src, Err: ErrSpecialFile}, opt)
 	}
 
 	if opt.RenameDestination != nil {
@@ -52,15 +90,23 @@ func switchboard(src, dest string, info os.FileInfo, opt Options) (err error) {
 		}
 	}
 
+	if err := opt.intent.ops.wait(opt.intent.ctx, 1); err != nil {
+		return onError(src, dest, canceled(src, err), opt)
+	}
+
 	switch {
 	case info.Mode()&os.ModeSymlink != 0:
 		err = onsymlink(src, dest, opt)
 	case info.IsDir():
 		err = dcopy(src, dest, info, opt)
 	case info.Mode()&os.ModeNamedPipe != 0:
-		err = pcopy(dest, info)
+		if err = pcopy(dest, info, opt.DestFS); err == nil {
+			opt.intent.report.namedPipe()
+		}
+	case action == SpecialRecreate:
+		err = mknod(src, dest, info, opt)
 	default:
-		err = fcopy(src, dest, info, opt)
+		err = fcopyOrLink(src, dest, info, opt)
 	}
 
 	return onError(src, dest, err, opt)
@@ -76,6 +122,7 @@ func copyNextOrSkip(src, dest string, info os.FileInfo, opt Options) error {
 			return err
 		}
 		if skip {
+			opt.intent.report.skipped()
 			return nil
 		}
 	}
@@ -86,6 +133,17 @@ func copyNextOrSkip(src, dest string, info os.FileInfo, opt Options) error {
 // with considering existence of parent directory
 // and file permission.
 func fcopy(src, dest string, info os.FileInfo, opt Options) (err error) {
+	tf, err := transformOf(src, dest, info, opt)
+	if err != nil {
+		return err
+	}
+	if tf != nil && tf.Dest != "" {
+		dest = tf.Dest
+	}
+
+	if yes, err := unchanged(src, dest, info, tf, opt); err != nil || yes {
+		return fskipped(src, err, opt)
+	}
 
 	var readcloser io.ReadCloser
 	if opt.FS != nil {
@@ -101,60 +159,220 @@ func fcopy(src, dest string, info os.FileInfo, opt Options) (err error) {
 	}
 	defer fclose(readcloser, &err)
 
-	if err = os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+	if err = opt.DestFS.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
 		return
 	}
 
-	f, err := os.Create(dest)
-	if err != nil {
-		return
+	var f WritableFile
+	var offset int64
+	target := dest
+	resuming := opt.Resume != ResumeNever && tf == nil
+	if resuming {
+		if yes, err := shouldWrite(src, dest, info, opt); err != nil || !yes {
+			return fskipped(src, err, opt)
+		}
+		if f, dest, offset, err = openPartial(src, target, info, opt); err != nil {
+			return
+		}
+		defer commitPartial(dest, target, opt, &err)
+	} else if opt.Atomic {
+		if yes, err := shouldWrite(src, dest, info, opt); err != nil || !yes {
+			return fskipped(src, err, opt)
+		}
+		if f, dest, err = createTemp(opt.DestFS, target); err != nil {
+			return
+		}
+		defer commitTemp(dest, target, opt, &err)
+	} else if f, err = openDest(src, dest, info, opt); err != nil || f == nil {
+		return fskipped(src, err, opt)
 	}
 	defer fclose(f, &err)
 
-	chmodfunc, err := opt.PermissionControl(info, dest)
+	chmodfunc, err := opt.PermissionControl(destInfo{info, opt.DestFS}, dest)
 	if err != nil {
 		return err
 	}
-	chmodfunc(&err)
-
-	var buf []byte = nil
-	var w io.Writer = f
-	var r io.Reader = readcloser
+	// The partial file is kept writable until it's complete, so that it can be resumed.
+	if !resuming {
+		chmodfunc(&err)
+	}
 
-	if opt.WrapReader != nil {
-		r = opt.WrapReader(r)
+	// Digest the src bytes as they are read, for Verify and Manifest.
+	var srcfile io.Reader = readcloser
+	var h hash.Hash
+	if opt.Verify || opt.Manifest != nil {
+		h = newHash(opt)
 	}
 
-	if opt.CopyBufferSize != 0 {
-		buf = make([]byte, opt.CopyBufferSize)
-		// Disable using `ReadFrom` by io.CopyBuffer.
-		// See https://github.com/otiai10/copy/pull/60#discussion_r627320811 for more details.
-		w = struct{ io.Writer }{f}
-		// r = struct{ io.Reader }{s}
+	// Skip what the partial file already has, for Resume.
+	if offset > 0 {
+		if err = skipPrefix(readcloser, offset, h); err != nil {
+			return err
+		}
+		opt.intent.progress.add(src, offset, false)
+	}
+
+	// Wrap the streams by Transform, and digest what is written instead,
+	// because the contents of dest differ from src.
+	var w WritableFile = f
+	var tw io.WriteCloser
+	var cw *countWriter
+	srch, readopt := h, opt
+	if tf != nil {
+		srch = nil
+		// OnProgress counts the bytes read from src, before Transform.Reader.
+		if opt.intent.progress != nil {
+			srcfile = progressReader{opt.intent.progress, src, srcfile}
+			readopt.intent.progress = nil
+		}
+		if tf.Reader != nil {
+			if srcfile, err = tf.Reader(srcfile); err != nil {
+				return err
+			}
+		}
+		var fw io.Writer = f
+		if h != nil {
+			fw = io.MultiWriter(f, h)
+		}
+		w = transformed{fw, f}
+		if tf.Writer != nil {
+			// Report.Bytes counts the bytes written into dest, instead of those given to Transform.Writer.
+			cw = &countWriter{w: fw}
+			if tw, err = tf.Writer(cw); err != nil {
+				return err
+			}
+			w = transformed{tw, f}
+		}
 	}
 
-	if _, err = io.CopyBuffer(w, r, buf); err != nil {
+	n, err := fcopyContents(w, srcfile, src, info, srch, readopt)
+	if err != nil {
 		return err
 	}
+	if tw != nil {
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		n = cw.n
+	}
+	n += offset
+	opt.intent.progress.add(src, 0, true)
+	opt.intent.report.file(n)
 
 	if opt.Sync {
 		err = f.Sync()
 	}
 
+	var sum []byte
+	if h != nil {
+		sum = h.Sum(nil)
+	}
+	if opt.Verify {
+		if err := verify(dest, sum, opt); err != nil {
+			return err
+		}
+	}
+
 	if opt.PreserveOwner {
-		if err := preserveOwner(src, dest, info); err != nil {
+		if err := preserveOwner(src, dest, info, opt.DestFS); err != nil {
+			return err
+		}
+	}
+	// Set after chown, which clears file capabilities.
+	if opt.PreserveXattrs != 0 {
+		if err := preserveXattrs(src, dest, opt); err != nil {
 			return err
 		}
 	}
 	if opt.PreserveTimes {
-		if err := preserveTimes(info, dest); err != nil {
+		if err := preserveTimes(info, dest, opt.DestFS); err != nil {
+			return err
+		}
+	}
+
+	if resuming {
+		if chmodfunc(&err); err != nil {
 			return err
 		}
 	}
 
+	if err := opt.Manifest.add(target, dest, sum, opt); err != nil {
+		return err
+	}
+
 	return
 }
 
+// fskipped is for a file which Update or OnFileExists decided not to overwrite.
+// It is not counted by Progress, as PreScan does not count it either.
+func fskipped(src string, err error, opt Options) error {
+	if err != nil {
+		return err
+	}
+	opt.intent.report.skipped()
+	return nil
+}
+
+// fcopyContents copies the contents of src into f,
+// by the fastest way available.
+// If h is given, the src bytes are digested into h, for Verify and Manifest.
+func fcopyContents(f WritableFile, srcfile io.Reader, src string, info os.FileInfo, h hash.Hash, opt Options) (int64, error) {
+	if cloned, err := reflink(f, srcfile, src, h, opt); err != nil {
+		return 0, err
+	} else if cloned {
+		opt.intent.progress.add(src, info.Size(), false)
+		return info.Size(), nil
+	}
+
+	if n, done, err := fcopySparse(f, srcfile, src, info, h, opt); err != nil || done {
+		return n, err
+	}
+
+	written, done, err := fcopyKernel(f, srcfile, src, info, h, opt)
+	if err != nil || done {
+		return written, err
+	}
+
+	var buf []byte = nil
+	var w io.Writer = f
+	r := fcopyReader(digesting(srcfile, h), src, opt)
+
+	if opt.CopyBufferSize != 0 {
+		buf = make([]byte, opt.CopyBufferSize)
+		// Disable using `ReadFrom` by io.CopyBuffer.
+		// See https://github.com/otiai10/copy/pull/60#discussion_r627320811 for more details.
+		w = struct{ io.Writer }{f}
+		// r = struct{ io.Reader }{s}
+	}
+
+	n, err := io.CopyBuffer(w, r, buf)
+	if err != nil && err == opt.intent.ctx.Err() {
+		return written + n, canceled(src, err)
+	}
+	return written + n, err
+}
+
+// fcopyReader wraps the reader of src file,
+// so that the copy can be limited, interrupted and reported.
+func fcopyReader(r io.Reader, src string, opt Options) io.Reader {
+	if opt.WrapReader != nil {
+		r = opt.WrapReader(r)
+	}
+
+	if opt.intent.ctx.Done() != nil {
+		r = contextReader{opt.intent.ctx, r}
+	}
+
+	if opt.intent.bytes != nil {
+		r = rateLimitReader{opt.intent.ctx, opt.intent.bytes, r}
+	}
+
+	if opt.intent.progress != nil {
+		r = progressReader{opt.intent.progress, src, r}
+	}
+	return r
+}
+
 // dcopy is for a directory,
 // with scanning contents inside the directory
 // and pass everything to "copy" recursively.
@@ -162,11 +380,12 @@ func dcopy(srcdir, destdir string, info os.FileInfo, opt Options) (err error) {
 	if skip, err := onDirExists(opt, srcdir, destdir); err != nil {
 		return err
 	} else if skip {
+		opt.intent.report.skipped()
 		return nil
 	}
 
 	// Make dest dir with 0755 so that everything writable.
-	chmodfunc, err := opt.PermissionControl(info, destdir)
+	chmodfunc, err := opt.PermissionControl(destInfo{info, opt.DestFS}, destdir)
 	if err != nil {
 		return err
 	}
@@ -197,6 +416,12 @@ func dcopy(srcdir, destdir string, info os.FileInfo, opt Options) (err error) {
 		contents = append(contents, info)
 	}
 
+	if opt.Mirror == MirrorDeleteBefore {
+		if err := mirror(srcdir, destdir, contents, opt); err != nil {
+			return err
+		}
+	}
+
 	if yes, err := shouldCopyDirectoryConcurrent(opt, srcdir, destdir); err != nil {
 		return err
 	} else if yes {
@@ -209,18 +434,31 @@ func dcopy(srcdir, destdir string, info os.FileInfo, opt Options) (err error) {
 		}
 	}
 
+	if opt.Mirror == MirrorDeleteAfter {
+		if err := mirror(srcdir, destdir, contents, opt); err != nil {
+			return err
+		}
+	}
+
 	if opt.PreserveTimes {
-		if err := preserveTimes(info, destdir); err != nil {
+		if err := preserveTimes(info, destdir, opt.DestFS); err != nil {
 			return err
 		}
 	}
 
 	if opt.PreserveOwner {
-		if err := preserveOwner(srcdir, destdir, info); err != nil {
+		if err := preserveOwner(srcdir, destdir, info, opt.DestFS); err != nil {
+			return err
+		}
+	}
+
+	if opt.PreserveXattrs != 0 {
+		if err := preserveXattrs(srcdir, destdir, opt); err != nil {
 			return err
 		}
 	}
 
+	opt.intent.report.dir()
 	return
 }
 
@@ -228,6 +466,10 @@ func dcopySequential(srcdir, destdir string, contents []os.FileInfo, opt Options
 	for _, content := range contents {
 		cs, cd := filepath.Join(srcdir, content.Name()), filepath.Join(destdir, content.Name())
 
+		if err := opt.intent.ctx.Err(); err != nil {
+			return canceled(cs, err)
+		}
+
 		if err := copyNextOrSkip(cs, cd, content, opt); err != nil {
 			// If any error, exit immediately
 			return err
@@ -241,11 +483,14 @@ func dcopyConcurrent(srcdir, destdir string, contents []os.FileInfo, opt Options
 	group, ctx := errgroup.WithContext(opt.intent.ctx)
 	getRoutine := func(cs, cd string, content os.FileInfo) func() error {
 		return func() error {
+			if err := ctx.Err(); err != nil {
+				return canceled(cs, err)
+			}
 			if content.IsDir() {
 				return copyNextOrSkip(cs, cd, content, opt)
 			}
 			if err := opt.intent.sem.Acquire(ctx, 1); err != nil {
-				return err
+				return canceled(cs, err)
 			}
 			err := copyNextOrSkip(cs, cd, content, opt)
 			opt.intent.sem.Release(1)
@@ -261,11 +506,14 @@ func dcopyConcurrent(srcdir, destdir string, contents []os.FileInfo, opt Options
 }
 
 func onDirExists(opt Options, srcdir, destdir string) (bool, error) {
-	_, err := os.Stat(destdir)
+	_, err := opt.DestFS.Lstat(destdir)
 	if err == nil && opt.OnDirExists != nil && destdir != opt.intent.dest {
 		switch opt.OnDirExists(srcdir, destdir) {
 		case Replace:
-			if err := os.RemoveAll(destdir); err != nil {
+			if backups(opt) {
+				return false, backup(destdir, opt)
+			}
+			if err := opt.DestFS.RemoveAll(destdir); err != nil {
 				return false, err
 			}
 		case Untouchable:
@@ -280,15 +528,32 @@ func onDirExists(opt Options, srcdir, destdir string) (bool, error) {
 func onsymlink(src, dest string, opt Options) error {
 	switch opt.OnSymlink(src) {
 	case Shallow:
-		if err := lcopy(src, dest); err != nil {
+		if err := lcopy(src, dest, opt); err != nil {
 			return err
 		}
-		if opt.PreserveTimes {
+		opt.intent.report.symlink()
+		if opt.PreserveXattrs != 0 {
+			if err := preserveXattrs(src, dest, opt); err != nil {
+				return err
+			}
+		}
+		if !opt.PreserveTimes {
+			return nil
+		}
+		if opt.FS == nil && opt.DestFS == OSFS {
 			return preserveLtimes(src, dest)
 		}
+		if destfs, ok := opt.DestFS.(lchtimesFS); ok {
+			info, err := lstat(src, opt)
+			if err != nil {
+				return err
+			}
+			spec := timeSpecOf(info)
+			return destfs.Lchtimes(dest, spec.Atime, spec.Mtime)
+		}
 		return nil
 	case Deep:
-		orig, err := os.Readlink(src)
+		orig, err := readlink(src, opt)
 		if err != nil {
 			return err
 		}
@@ -296,7 +561,7 @@ func onsymlink(src, dest string, opt Options) error {
 			// orig is a relative link: need to add src dir to orig
 			orig = filepath.Join(filepath.Dir(src), orig)
 		}
-		info, err := os.Lstat(orig)
+		info, err := lstat(orig, opt)
 		if err != nil {
 			return err
 		}
@@ -304,32 +569,63 @@ func onsymlink(src, dest string, opt Options) error {
 	case Skip:
 		fallthrough
 	default:
+		opt.intent.report.skipped()
 		return nil // do nothing
 	}
 }
 
 // lcopy is for a symlink,
 // with just creating a new symlink by replicating src symlink.
-func lcopy(src, dest string) error {
-	orig, err := os.Readlink(src)
+func lcopy(src, dest string, opt Options) error {
+	destfs := opt.DestFS
+	orig, err := readlink(src, opt)
 	// @See https://github.com/otiai10/copy/issues/111
 	// TODO: This might be controlled by Options in the future.
 	if err != nil {
 		if os.IsNotExist(err) { // Copy symlink even if not existing
-			return os.Symlink(src, dest)
+			return destfs.Symlink(src, dest)
 		}
 		return err
 	}
 
+	if backups(opt) {
+		if err := backup(dest, opt); err != nil {
+			return err
+		}
+	}
+
 	// @See https://github.com/otiai10/copy/issues/132
 	// TODO: Control by SymlinkExistsAction
-	if _, err := os.Lstat(dest); err == nil {
-		if err := os.Remove(dest); err != nil {
+	if _, err := destfs.Lstat(dest); err == nil {
+		if err := destfs.RemoveAll(dest); err != nil {
 			return err
 		}
 	}
 
-	return os.Symlink(orig, dest)
+	return destfs.Symlink(orig, dest)
+}
+
+// readlinkFS is fs.FS which can read symlinks, such as MemFS.
+type readlinkFS interface {
+	ReadLink(name string) (string, error)
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// readlink reads the symlink of src,
+// from Options.FS if it can read symlinks, otherwise from the OS filesystem.
+func readlink(src string, opt Options) (string, error) {
+	if fsys, ok := opt.FS.(readlinkFS); ok {
+		return fsys.ReadLink(src)
+	}
+	return os.Readlink(src)
+}
+
+// lstat is the same as readlink, but for os.Lstat.
+func lstat(src string, opt Options) (os.FileInfo, error) {
+	if fsys, ok := opt.FS.(readlinkFS); ok {
+		return fsys.Lstat(src)
+	}
+	return os.Lstat(src)
 }
 
 // fclose ANYHOW closes file,
@@ -341,6 +637,25 @@ func fclose(f io.Closer, reported *error) {
 	}
 }
 
+// contextReader stops reading as soon as ctx is done,
+// so that a long file copy can be interrupted.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// canceled wraps the error of done context with the path in progress.
+func canceled(path string, err error) error {
+	return &fs.PathError{Op: "copy", Path: path, Err: err}
+}
+
 // onError lets caller to handle errors
 // occurred when copying a file.
 func onError(src, dest string, err error, opt Options) error {
@@ -348,5 +663,16 @@ func onError(src, dest string, err error, opt Options) error {
 		return err
 	}
 
-	return opt.OnError(src, dest, err)
+	if err == nil {
+		return opt.OnError(src, dest, err)
+	}
+	// Cancellation is not an error of src, so it is never swallowed.
+	if ctxerr := opt.intent.ctx.Err(); ctxerr != nil && errors.Is(err, ctxerr) {
+		return err
+	}
+	if handled := opt.OnError(src, dest, err); handled != nil {
+		return handled
+	}
+	opt.intent.report.suppressed(src, dest, err)
+	return nil
 }
@@ -0,0 +1,30 @@
+package copy
+
+import (
+	"hash"
+	"io"
+	"os"
+)
+
+// fcopyKernel copies srcfile into f in the kernel by copy_file_range(2),
+// if requested by opt and nothing would be bypassed by it.
+// It reports how many bytes were copied, and whether or not it reached EOF.
+// If not, the rest should be copied by the loop, from the current offsets.
+func fcopyKernel(f WritableFile, srcfile io.Reader, src string, info os.FileInfo, h hash.Hash, opt Options) (int64, bool, error) {
+	// WrapReader and CopyBufferSize deliberately disable the kernel-side copy,
+	// and RateLimit, Verify and Manifest need the bytes read by Go.
+	if !opt.CopyFileRange || opt.WrapReader != nil || opt.CopyBufferSize != 0 || opt.RateLimit > 0 || h != nil {
+		return 0, false, nil
+	}
+	d, ok := f.(*os.File)
+	s, ok2 := srcfile.(*os.File)
+	// Files such as /proc/* say their size is 0 but have contents.
+	if !ok || !ok2 || info.Size() == 0 {
+		return 0, false, nil
+	}
+	n, done, err := copyFileRange(d, s, src, opt)
+	if done {
+		opt.intent.report.copyFileRange()
+	}
+	return n, done, err
+}
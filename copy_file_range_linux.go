@@ -0,0 +1,41 @@
+//go:build linux
+
+package copy
+
+import (
+	"io/fs"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyFileRangeChunk is the max bytes for each copy_file_range(2),
+// so that ctx and OnProgress can be checked in the middle.
+const copyFileRangeChunk = 8 * 1024 * 1024
+
+func copyFileRange(dest, src *os.File, path string, opt Options) (written int64, done bool, err error) {
+	for {
+		if err := opt.intent.ctx.Err(); err != nil {
+			return written, false, canceled(path, err)
+		}
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dest.Fd()), nil, copyFileRangeChunk, 0)
+		if n > 0 {
+			written += int64(n)
+			opt.intent.progress.add(path, int64(n), false)
+		}
+		switch err {
+		case nil:
+			if n == 0 {
+				// Nothing copied at all, such as sysfs, should be read by the loop.
+				return written, written > 0, nil
+			}
+		case unix.EINTR:
+		case unix.ENOSYS, unix.EXDEV, unix.EINVAL, unix.EIO, unix.EOPNOTSUPP, unix.EPERM:
+			// Such as old kernels or crossing filesystems,
+			// the loop can continue from the current offsets.
+			return written, false, nil
+		default:
+			return written, false, &fs.PathError{Op: "copy_file_range", Path: path, Err: err}
+		}
+	}
+}
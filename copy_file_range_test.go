@@ -0,0 +1,42 @@
+//go:build linux
+
+package copy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/otiai10/mint"
+)
+
+func TestOptions_CopyFileRange(t *testing.T) {
+	src := t.TempDir()
+	Expect(t, os.WriteFile(filepath.Join(src, "small"), []byte("small file"), 0o644)).ToBe(nil)
+	Expect(t, os.WriteFile(filepath.Join(src, "large"), make([]byte, copyFileRangeChunk+1), 0o644)).ToBe(nil)
+	dest := t.TempDir()
+	report, err := CopyWithReport(src, dest, Options{CopyFileRange: true})
+	Expect(t, err).ToBe(nil)
+	Expect(t, report.CopyFileRange).ToBe(int64(2))
+	Expect(t, report.Bytes).ToBe(int64(len("small file") + copyFileRangeChunk + 1))
+	b, err := os.ReadFile(filepath.Join(dest, "small"))
+	Expect(t, err).ToBe(nil)
+	Expect(t, string(b)).ToBe("small file")
+
+	When(t, "CopyBufferSize is given", func(t *testing.T) {
+		report, err := CopyWithReport(src, t.TempDir(), Options{CopyFileRange: true, CopyBufferSize: 512})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.CopyFileRange).ToBe(int64(0))
+	})
+
+	When(t, "src is a file in /proc", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "status")
+		report, err := CopyWithReport("/proc/self/status", dest, Options{CopyFileRange: true})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.CopyFileRange).ToBe(int64(0))
+		b, err := os.ReadFile(dest)
+		Expect(t, err).ToBe(nil)
+		Expect(t, strings.Contains(string(b), "Name:")).ToBe(true)
+	})
+}
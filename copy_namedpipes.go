@@ -3,15 +3,23 @@
 package copy
 
 import (
+	"io/fs"
 	"os"
 	"path/filepath"
 	"syscall"
 )
 
 // pcopy is for just named pipes
-func pcopy(dest string, info os.FileInfo) error {
-	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+func pcopy(dest string, info os.FileInfo, destfs WritableFS) error {
+	if err := destfs.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
 		return err
 	}
+	if fifofs, ok := destfs.(mkfifoFS); ok {
+		return fifofs.Mkfifo(dest, info.Mode())
+	}
+	// Other WritableFS can not be bypassed by the OS path.
+	if destfs != OSFS {
+		return &fs.PathError{Op: "mkfifo", Path: dest, Err: errMkfifoUnsupported}
+	}
 	return syscall.Mkfifo(dest, uint32(info.Mode()))
 }
@@ -9,6 +9,9 @@ import (
 // TODO: check plan9 netbsd aix illumos solaris in future
 
 // pcopy is for just named pipes. Windows doesn't support them
-func pcopy(dest string, info os.FileInfo) error {
+func pcopy(dest string, info os.FileInfo, destfs WritableFS) error {
+	if destfs, ok := destfs.(mkfifoFS); ok {
+		return destfs.Mkfifo(dest, info.Mode())
+	}
 	return nil
 }
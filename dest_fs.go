@@ -0,0 +1,99 @@
+package copy
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// WritableFS is the filesystem which Copy writes into.
+// By default, it is OSFS, the OS filesystem.
+// e.g., You can copy files into in-memory tree, or into a prefixed sandbox.
+// If it also has Mkfifo or Lchtimes method as MemFS does,
+// named pipes and times of symlinks are copied by them.
+// If it has Exchange method, AtomicTree swaps directories by it.
+// If it has Link method, PreserveHardLinks links files by it.
+type WritableFS interface {
+	MkdirAll(path string, perm os.FileMode) error
+	OpenFile(name string, flag int, perm os.FileMode) (WritableFile, error)
+	Create(name string) (WritableFile, error)
+	Symlink(oldname, newname string) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Chown(name string, uid, gid int) error
+	Lstat(name string) (os.FileInfo, error)
+	RemoveAll(path string) error
+	Rename(oldpath, newpath string) error
+}
+
+// WritableFile is a file opened by WritableFS.
+type WritableFile interface {
+	io.Writer
+	io.Closer
+	Sync() error
+}
+
+// OSFS is the WritableFS which writes into the OS filesystem.
+var OSFS WritableFS = osFS{}
+
+type osFS struct{}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (WritableFile, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Create(name string) (WritableFile, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Symlink(oldname, newname string) error     { return os.Symlink(oldname, newname) }
+func (osFS) Chmod(name string, mode os.FileMode) error { return os.Chmod(name, mode) }
+func (osFS) Chown(name string, uid, gid int) error     { return os.Chown(name, uid, gid) }
+func (osFS) Lstat(name string) (os.FileInfo, error)    { return os.Lstat(name) }
+func (osFS) RemoveAll(path string) error               { return os.RemoveAll(path) }
+func (osFS) Rename(oldpath, newpath string) error      { return os.Rename(oldpath, newpath) }
+func (osFS) Link(oldname, newname string) error        { return os.Link(oldname, newname) }
+func (osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+var errMkfifoUnsupported = errors.New("mkfifo is not supported")
+
+type mkfifoFS interface {
+	Mkfifo(name string, mode os.FileMode) error
+}
+
+type lchtimesFS interface {
+	Lchtimes(name string, atime, mtime time.Time) error
+}
+
+type exchangeFS interface {
+	Exchange(oldpath, newpath string) error
+}
+
+// destInfo carries the WritableFS to PermissionControlFunc,
+// without changing the signature of PermissionControlFunc.
+type destInfo struct {
+	fs.FileInfo
+	destfs WritableFS
+}
+
+// destFSOf returns the WritableFS which the entry is copied into.
+func destFSOf(srcinfo fs.FileInfo) WritableFS {
+	if info, ok := srcinfo.(destInfo); ok {
+		return info.destfs
+	}
+	return OSFS
+}
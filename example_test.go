@@ -42,3 +42,21 @@ func ExampleOptions() {
 	// Skipped: true
 
 }
+
+func ExampleFilter() {
+
+	err := Copy(
+		"test/data/example",
+		"test/data.copy/example_with_filter",
+		Options{
+			Skip: NewFilter("test/data/example", "!.git-like/**").Skip,
+		},
+	)
+	fmt.Println("Error:", err)
+	_, err = os.Stat("test/data.copy/example_with_filter/.git-like")
+	fmt.Println("Skipped:", os.IsNotExist(err))
+
+	// Output:
+	// Error: <nil>
+	// Skipped: true
+}
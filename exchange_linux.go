@@ -0,0 +1,17 @@
+//go:build linux
+
+package copy
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Exchange swaps oldpath and newpath atomically by renameat2(RENAME_EXCHANGE).
+func (osFS) Exchange(oldpath, newpath string) error {
+	if err := unix.Renameat2(unix.AT_FDCWD, oldpath, unix.AT_FDCWD, newpath, unix.RENAME_EXCHANGE); err != nil {
+		return &os.LinkError{Op: "exchange", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
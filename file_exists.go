@@ -0,0 +1,89 @@
+package copy
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// ErrExists is the error when OnFileExists returns FileError.
+// It also matches fs.ErrExist by errors.Is.
+var ErrExists = fmt.Errorf("dest %w", fs.ErrExist)
+
+// overwrites decides whether existing dest should be overwritten by the action.
+func overwrites(action FileExistsAction, dest string, info, destinfo os.FileInfo) (bool, error) {
+	switch action {
+	case FileSkip:
+		return false, nil
+	case FileError:
+		return false, &fs.PathError{Op: "copy", Path: dest, Err: ErrExists}
+	case FileOverwriteIfNewer:
+		return info.ModTime().After(destinfo.ModTime()), nil
+	case FileOverwriteIfDifferentSize:
+		return info.Size() != destinfo.Size(), nil
+	default:
+		return true, nil
+	}
+}
+
+// shouldWrite reports whether src should be written into dest,
+// either because dest does not exist or because OnFileExists lets it be overwritten.
+func shouldWrite(src, dest string, info os.FileInfo, opt Options) (bool, error) {
+	if opt.OnFileExists == nil {
+		return true, nil
+	}
+	destinfo, err := opt.DestFS.Lstat(dest)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return overwrites(opt.OnFileExists(src, dest, info, destinfo), dest, info, destinfo)
+}
+
+// openDest opens dest to write into, as OnFileExists decides.
+// If dest does not exist, it's created with O_EXCL,
+// so that a file created by someone else meanwhile is never clobbered.
+// Existing dest is backed up before it's overwritten, if Backup or BackupName is given.
+// It returns nil if dest should be left as it is.
+func openDest(src, dest string, info os.FileInfo, opt Options) (WritableFile, error) {
+	if opt.OnFileExists == nil && !backups(opt) {
+		return opt.DestFS.Create(dest)
+	}
+	destinfo, err := opt.DestFS.Lstat(dest)
+	if os.IsNotExist(err) {
+		var f WritableFile
+		f, err = opt.DestFS.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			return f, err
+		}
+		// dest has been created since Lstat, so ask again.
+		destinfo, err = opt.DestFS.Lstat(dest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	action := FileOverwrite
+	if opt.OnFileExists != nil {
+		action = opt.OnFileExists(src, dest, info, destinfo)
+	}
+	if yes, err := overwrites(action, dest, info, destinfo); err != nil || !yes {
+		return nil, err
+	}
+	if backups(opt) {
+		if err := backup(dest, opt); err != nil {
+			return nil, err
+		}
+		return opt.DestFS.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	}
+	f, err := opt.DestFS.Create(dest)
+	if err == nil || !os.IsPermission(err) || action != FileForceOverwrite {
+		return f, err
+	}
+	// Like "cp -f", remove dest which cannot be opened, and try again.
+	if err := opt.DestFS.RemoveAll(dest); err != nil {
+		return nil, err
+	}
+	return opt.DestFS.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+}
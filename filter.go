@@ -0,0 +1,128 @@
+package copy
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Filter decides which entries should be skipped by ordered rules,
+// matching paths relative to Root. Use Filter.Skip as Options.Skip.
+//
+// The first rule which matches an entry decides whether it's included or excluded.
+// If no rule matches, files are excluded if there is any include rule,
+// otherwise included. Directories are excluded only by exclude rules,
+// so that included files under them can be found.
+type Filter struct {
+	// Root is the src given to Copy, which paths are relative to.
+	Root string
+
+	Rules []Rule
+}
+
+// Rule is an include or exclude rule of Filter.
+type Rule struct {
+	// Pattern is a slash-separated glob relative to Root,
+	// where "**" matches zero or more directories, e.g. "**/*.go" or "vendor/**".
+	// Empty pattern matches everything.
+	Pattern string
+
+	// Exclude makes the rule exclude the matched entries, instead of including them.
+	Exclude bool
+
+	// Match narrows the entries matched by Pattern, such as by LargerThan or OfType.
+	Match func(info os.FileInfo) bool
+}
+
+// NewFilter creates a Filter from patterns, where "!" in front of a pattern makes it exclude.
+// e.g. NewFilter("src", "!vendor/**", "**/*.go")
+func NewFilter(root string, patterns ...string) *Filter {
+	f := &Filter{Root: root}
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			f.Rules = append(f.Rules, Rule{Pattern: pattern[1:], Exclude: true})
+		} else {
+			f.Rules = append(f.Rules, Rule{Pattern: pattern})
+		}
+	}
+	return f
+}
+
+// Skip can be used as Options.Skip.
+func (f *Filter) Skip(info os.FileInfo, src, dest string) (bool, error) {
+	rel, err := filepath.Rel(f.Root, src)
+	if err != nil {
+		return false, err
+	}
+	rel = filepath.ToSlash(rel)
+	includes := false
+	for _, rule := range f.Rules {
+		if !rule.Exclude {
+			includes = true
+		}
+		if (rule.Pattern == "" || matchGlob(rule.Pattern, rel)) && (rule.Match == nil || rule.Match(info)) {
+			return rule.Exclude, nil
+		}
+	}
+	return includes && !info.IsDir(), nil
+}
+
+// matchGlob reports whether name matches pattern, both of which are slash-separated.
+// Each element is matched by path.Match, except "**" which matches zero or more elements.
+func matchGlob(pattern, name string) bool {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchElems(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// LargerThan matches files larger than size bytes.
+func LargerThan(size int64) func(os.FileInfo) bool {
+	return func(info os.FileInfo) bool { return !info.IsDir() && info.Size() > size }
+}
+
+// SmallerThan matches files smaller than size bytes.
+func SmallerThan(size int64) func(os.FileInfo) bool {
+	return func(info os.FileInfo) bool { return !info.IsDir() && info.Size() < size }
+}
+
+// OlderThan matches entries modified more than age ago.
+func OlderThan(age time.Duration) func(os.FileInfo) bool {
+	return func(info os.FileInfo) bool { return time.Since(info.ModTime()) > age }
+}
+
+// NewerThan matches entries modified less than age ago.
+func NewerThan(age time.Duration) func(os.FileInfo) bool {
+	return func(info os.FileInfo) bool { return time.Since(info.ModTime()) < age }
+}
+
+// HasMode matches entries which have all the mode bits, e.g. 0111 or os.ModeSetuid.
+func HasMode(bits os.FileMode) func(os.FileInfo) bool {
+	return func(info os.FileInfo) bool { return info.Mode()&bits == bits }
+}
+
+// OfType matches entries of the type, e.g. os.ModeDir or os.ModeSymlink.
+// Zero matches regular files.
+func OfType(typ os.FileMode) func(os.FileInfo) bool {
+	return func(info os.FileInfo) bool { return info.Mode().Type() == typ }
+}
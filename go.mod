@@ -4,6 +4,7 @@ go 1.18
 
 require (
 	github.com/otiai10/mint v1.6.3
+	golang.org/x/crypto v0.24.0
 	golang.org/x/sync v0.8.0
 	golang.org/x/sys v0.24.0
 )
@@ -0,0 +1,122 @@
+package copy
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// inode identifies a file in the source filesystem.
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// hardlinks remembers the first dest of each inode,
+// so that later links can be linked to it instead of copied again.
+type hardlinks struct {
+	mu   sync.Mutex
+	seen map[inode]*hardlink
+}
+
+type hardlink struct {
+	done chan struct{}
+	dest string
+	err  error
+}
+
+func newHardlinks(opt Options) *hardlinks {
+	if !opt.PreserveHardLinks {
+		return nil
+	}
+	return &hardlinks{seen: map[inode]*hardlink{}}
+}
+
+type linkFS interface {
+	Link(oldname, newname string) error
+}
+
+// first reports the first link of the inode of src,
+// or registers dest as the first link if not seen yet.
+// It also reports false if src does not need to be linked.
+func (h *hardlinks) first(dest string, info os.FileInfo, opt Options) (*hardlink, bool) {
+	if h == nil {
+		return nil, false
+	}
+	if _, ok := opt.DestFS.(linkFS); !ok {
+		return nil, false
+	}
+	key, ok := inodeOf(info)
+	if !ok {
+		return nil, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if first, seen := h.seen[key]; seen {
+		return first, true
+	}
+	h.seen[key] = &hardlink{done: make(chan struct{}), dest: dest}
+	return h.seen[key], false
+}
+
+// fcopyOrLink links dest to the first copy of the same inode,
+// or copies src by fcopy if it is the first one.
+// Even if the first one is being copied by another worker,
+// it waits for the copy to be finished.
+func fcopyOrLink(src, dest string, info os.FileInfo, opt Options) error {
+	// Links are made among the dests renamed by Transform, which fcopy writes.
+	link, tf, err := transformedDest(src, dest, info, opt)
+	if err != nil {
+		return err
+	}
+	first, seen := opt.intent.hardlinks.first(link, info, opt)
+	if first == nil {
+		return fcopy(src, dest, info, opt)
+	}
+	if !seen {
+		first.err = fcopy(src, dest, info, opt)
+		close(first.done)
+		return first.err
+	}
+
+	select {
+	case <-first.done:
+	case <-opt.intent.ctx.Done():
+		return canceled(src, opt.intent.ctx.Err())
+	}
+	if first.err != nil {
+		return fcopy(src, dest, info, opt)
+	}
+
+	if yes, err := unchanged(src, link, info, tf, opt); err != nil || yes {
+		return fskipped(src, err, opt)
+	}
+	if err := opt.DestFS.MkdirAll(filepath.Dir(link), os.ModePerm); err != nil {
+		return err
+	}
+	// Like O_EXCL of openDest, existing dest is never replaced before OnFileExists is asked.
+	err = opt.DestFS.(linkFS).Link(first.dest, link)
+	if os.IsExist(err) {
+		if yes, err := shouldWrite(src, link, info, opt); err != nil || !yes {
+			return fskipped(src, err, opt)
+		}
+		if err := unlink(link, opt); err != nil {
+			return err
+		}
+		err = opt.DestFS.(linkFS).Link(first.dest, link)
+	}
+	if err != nil {
+		return err
+	}
+	opt.intent.progress.add(src, 0, true)
+	opt.intent.report.hardLink()
+	return opt.Manifest.link(first.dest, link, opt)
+}
+
+// unlink makes room for a link at dest, backing up dest if Backup or BackupName is given.
+func unlink(dest string, opt Options) error {
+	if backups(opt) {
+		return backup(dest, opt)
+	}
+	return opt.DestFS.RemoveAll(dest)
+}
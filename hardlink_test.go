@@ -0,0 +1,103 @@
+//go:build !windows && !plan9
+
+package copy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/otiai10/mint"
+)
+
+func TestOptions_PreserveHardLinks(t *testing.T) {
+	src := t.TempDir()
+	Expect(t, os.MkdirAll(filepath.Join(src, "a", "b"), os.ModePerm)).ToBe(nil)
+	Expect(t, os.WriteFile(filepath.Join(src, "a", "orig.txt"), []byte("hard link"), 0o644)).ToBe(nil)
+	for _, name := range []string{"link1.txt", "b/link2.txt", "b/link3.txt"} {
+		Expect(t, os.Link(filepath.Join(src, "a", "orig.txt"), filepath.Join(src, "a", name))).ToBe(nil)
+	}
+
+	When(t, "PreserveHardLinks is true", func(t *testing.T) {
+		for _, opt := range []Options{
+			{PreserveHardLinks: true},
+			{PreserveHardLinks: true, NumOfWorkers: 4, PreferConcurrent: func(string, string) (bool, error) { return true, nil }},
+		} {
+			dest := filepath.Join(t.TempDir(), "dest")
+			report, err := CopyWithReport(src, dest, opt)
+			Expect(t, err).ToBe(nil)
+			Expect(t, report.Files).ToBe(int64(1))
+			Expect(t, report.HardLinks).ToBe(int64(3))
+			orig, err := os.Stat(filepath.Join(dest, "a", "orig.txt"))
+			Expect(t, err).ToBe(nil)
+			for _, name := range []string{"link1.txt", "b/link2.txt", "b/link3.txt"} {
+				info, err := os.Stat(filepath.Join(dest, "a", name))
+				Expect(t, err).ToBe(nil)
+				Expect(t, os.SameFile(orig, info)).ToBe(true)
+			}
+		}
+	})
+
+	When(t, "PreserveHardLinks is false", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		Expect(t, Copy(src, dest)).ToBe(nil)
+		orig, err := os.Stat(filepath.Join(dest, "a", "orig.txt"))
+		Expect(t, err).ToBe(nil)
+		info, err := os.Stat(filepath.Join(dest, "a", "link1.txt"))
+		Expect(t, err).ToBe(nil)
+		Expect(t, os.SameFile(orig, info)).ToBe(false)
+	})
+
+	When(t, "a later link already exists in dest", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		Expect(t, os.MkdirAll(filepath.Join(dest, "a"), os.ModePerm)).ToBe(nil)
+		Expect(t, os.WriteFile(filepath.Join(dest, "a", "link1.txt"), []byte("precious"), 0o644)).ToBe(nil)
+
+		skip := Options{PreserveHardLinks: true, OnFileExists: func(string, string, os.FileInfo, os.FileInfo) FileExistsAction { return FileSkip }}
+		ops, err := Plan(src, dest, skip)
+		Expect(t, err).ToBe(nil)
+		for _, op := range ops {
+			if op.Dest == filepath.Join(dest, "a", "link1.txt") {
+				Expect(t, op.Type).ToBe(OpSkip)
+			}
+		}
+		report, err := CopyWithReport(src, dest, skip)
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.HardLinks).ToBe(int64(2))
+		Expect(t, mustRead(t, filepath.Join(dest, "a", "link1.txt"))).ToBe("precious")
+
+		Expect(t, Copy(src, dest, Options{PreserveHardLinks: true, Backup: BackupSimple})).ToBe(nil)
+		Expect(t, mustRead(t, filepath.Join(dest, "a", "link1.txt~"))).ToBe("precious")
+		Expect(t, mustRead(t, filepath.Join(dest, "a", "link1.txt"))).ToBe("hard link")
+	})
+
+	When(t, "Transform renames dest", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		report, err := CopyWithReport(src, dest, Options{PreserveHardLinks: true, Transform: GzipCompress})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.HardLinks).ToBe(int64(3))
+		orig, err := os.Stat(filepath.Join(dest, "a", "orig.txt.gz"))
+		Expect(t, err).ToBe(nil)
+		info, err := os.Stat(filepath.Join(dest, "a", "b", "link2.txt.gz"))
+		Expect(t, err).ToBe(nil)
+		Expect(t, os.SameFile(orig, info)).ToBe(true)
+	})
+
+	When(t, "DestFS is MemFS", func(t *testing.T) {
+		mem := NewMemFS()
+		Expect(t, Copy(src, "dest", Options{PreserveHardLinks: true, DestFS: mem})).ToBe(nil)
+		ops, err := Plan(src, "dest", Options{PreserveHardLinks: true, DestFS: mem})
+		Expect(t, err).ToBe(nil)
+		links := 0
+		for _, op := range ops {
+			if op.Type == OpLink {
+				links++
+			}
+		}
+		Expect(t, links).ToBe(3)
+		Expect(t, mem.Chmod("dest/a/orig.txt", 0o600)).ToBe(nil)
+		info, err := mem.Lstat("dest/a/b/link3.txt")
+		Expect(t, err).ToBe(nil)
+		Expect(t, info.Mode().Perm()).ToBe(os.FileMode(0o600))
+	})
+}
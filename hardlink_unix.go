@@ -0,0 +1,16 @@
+//go:build !windows && !plan9
+
+package copy
+
+import (
+	"os"
+	"syscall"
+)
+
+func inodeOf(info os.FileInfo) (inode, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink <= 1 {
+		return inode{}, false
+	}
+	return inode{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,167 @@
+package copy
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Ignore skips entries ignored by ignore files such as .gitignore,
+// found in any directory under Root, as Git does.
+// Use Ignore.Skip as Options.Skip. Because ignored directories are skipped,
+// Copy does not descend into them.
+type Ignore struct {
+	// Root is the src given to Copy.
+	Root string
+
+	// Names are the names of ignore files, e.g. ".gitignore", ".ignore" or your own.
+	// Patterns in the files of the same directory are applied in this order.
+	Names []string
+
+	// FS is the filesystem to read ignore files, which should be Options.FS.
+	// If nil, the OS filesystem is used.
+	FS fs.FS
+
+	mu    sync.Mutex
+	rules map[string][]ignoreRule
+}
+
+type ignoreRule struct {
+	// dir is the slash-separated directory relative to Root, where the ignore file is.
+	dir      string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// NewIgnore creates an Ignore which reads .gitignore and .ignore,
+// or the given names of ignore files instead.
+func NewIgnore(root string, names ...string) *Ignore {
+	if len(names) == 0 {
+		names = []string{".gitignore", ".ignore"}
+	}
+	return &Ignore{Root: root, Names: names}
+}
+
+// Skip can be used as Options.Skip.
+func (ig *Ignore) Skip(info os.FileInfo, src, dest string) (bool, error) {
+	rel, err := filepath.Rel(ig.Root, src)
+	if err != nil {
+		return false, err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || strings.HasPrefix(rel, "../") {
+		return false, nil
+	}
+
+	// Patterns in deeper directories override the upper ones,
+	// and the later patterns override the earlier ones, thus the last match wins.
+	ignored := false
+	dirs := []string{"."}
+	if d := path.Dir(rel); d != "." {
+		parts := strings.Split(d, "/")
+		for i := range parts {
+			dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+		}
+	}
+	for _, dir := range dirs {
+		rules, err := ig.load(dir)
+		if err != nil {
+			return false, err
+		}
+		for _, rule := range rules {
+			if rule.match(rel, info.IsDir()) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored, nil
+}
+
+// load reads the ignore files in dir, only once.
+func (ig *Ignore) load(dir string) ([]ignoreRule, error) {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	if rules, ok := ig.rules[dir]; ok {
+		return rules, nil
+	}
+	if ig.rules == nil {
+		ig.rules = map[string][]ignoreRule{}
+	}
+	rules := []ignoreRule{}
+	for _, name := range ig.Names {
+		p := filepath.Join(ig.Root, filepath.FromSlash(dir), name)
+		var b []byte
+		var err error
+		if ig.FS != nil {
+			b, err = fs.ReadFile(ig.FS, filepath.ToSlash(p))
+		} else {
+			b, err = os.ReadFile(p)
+		}
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		rules = append(rules, parseIgnore(dir, b)...)
+	}
+	ig.rules[dir] = rules
+	return rules, nil
+}
+
+// parseIgnore parses the patterns of gitignore(5).
+func parseIgnore(dir string, b []byte) []ignoreRule {
+	rules := []ignoreRule{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if !strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{dir: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate, line = true, line[1:]
+		} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly, line = true, strings.TrimSuffix(line, "/")
+		}
+		// A pattern with a slash at the beginning or in the middle is relative to dir,
+		// otherwise it matches at any level below dir.
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.pattern = strings.ReplaceAll(line, "[!", "[^")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (rule ignoreRule) match(rel string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	if rule.dir != "." {
+		if !strings.HasPrefix(rel, rule.dir+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(rel, rule.dir+"/")
+	}
+	if !rule.anchored {
+		return matchGlob(rule.pattern, path.Base(rel))
+	}
+	return matchGlob(rule.pattern, rel)
+}
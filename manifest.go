@@ -0,0 +1,246 @@
+package copy
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errUnknownAlgorithm = errors.New("manifest needs Hash for the algorithm")
+
+var errHashMismatch = errors.New("Manifest.Hash differs from Options.Hash")
+
+// Manifest is the list of files copied, with their digests.
+// Give an empty Manifest to Options.Manifest, and it's filled while copying.
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+
+	// Hash is the hash function of the digests.
+	// It's set from Options.Hash when copying, and SHA256 is used if nil.
+	// If given, the digests are taken by it, and Options.Hash must be the same if given too.
+	Hash func() hash.Hash `json:"-"`
+
+	// Algorithm is the name of Hash, "sha256" or "blake2b-256", or "custom" for others,
+	// so that VerifyManifest can find Hash of the manifest read from JSON.
+	// It's set when copying, unless given.
+	Algorithm string `json:"algorithm"`
+
+	mu sync.Mutex
+}
+
+// ManifestEntry represents a file copied.
+type ManifestEntry struct {
+	// Path is the slash-separated path relative to dest.
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	// Digest is the hex-encoded digest of the contents.
+	Digest string `json:"digest"`
+}
+
+// add records the dest file which is copied, with the digest of the src bytes.
+// The file is written at tmp, which differs from dest only when Atomic.
+func (m *Manifest) add(dest, tmp string, sum []byte, opt Options) error {
+	if m == nil {
+		return nil
+	}
+	info, err := opt.DestFS.Lstat(tmp)
+	if err != nil {
+		return err
+	}
+	entry := ManifestEntry{Size: info.Size(), Mode: info.Mode(), ModTime: info.ModTime(), Digest: hex.EncodeToString(sum)}
+	return m.put(dest, entry, opt)
+}
+
+// link records the dest file which is linked to first, with the same digest.
+func (m *Manifest) link(first, dest string, opt Options) error {
+	if m == nil {
+		return nil
+	}
+	rel, err := manifestPath(first, opt)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range m.Entries {
+		if entry.Path == rel {
+			if entry.Path, err = manifestPath(dest, opt); err != nil {
+				return err
+			}
+			m.Entries = append(m.Entries, entry)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *Manifest) put(dest string, entry ManifestEntry, opt Options) (err error) {
+	if entry.Path, err = manifestPath(dest, opt); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Hash == nil {
+		m.Hash = opt.Hash
+	}
+	if m.Algorithm == "" {
+		m.Algorithm = algorithmOf(m.Hash)
+	}
+	m.Entries = append(m.Entries, entry)
+	return nil
+}
+
+// sort sorts the entries by path, because they can be added concurrently.
+func (m *Manifest) sort() {
+	if m == nil {
+		return
+	}
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Path < m.Entries[j].Path })
+}
+
+// manifestPath returns the path of dest relative to the root of dest.
+// The root which is not a directory is recorded by the name of its final dest,
+// which can be renamed by Transform or RenameDestination.
+func manifestPath(dest string, opt Options) (string, error) {
+	rel, err := filepath.Rel(opt.intent.dest, dest)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = filepath.Base(dest)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// hashFor returns the hash function to take the digests by,
+// which is Hash of the manifest if given, otherwise newHash.
+func (m *Manifest) hashFor(newHash func() hash.Hash) (func() hash.Hash, error) {
+	if m == nil || m.Hash == nil {
+		return newHash, nil
+	}
+	// Hash functions can not be compared, but the digests of nothing tell them apart.
+	if newHash != nil && !bytes.Equal(m.Hash().Sum(nil), newHash().Sum(nil)) {
+		return nil, errHashMismatch
+	}
+	return m.Hash, nil
+}
+
+// WriteSums writes the manifest in the format of sha256sum, or b2sum for BLAKE2b256,
+// so that it can be checked by "sha256sum -c" in dest.
+func (m *Manifest) WriteSums(w io.Writer) error {
+	for _, entry := range m.Entries {
+		if _, err := fmt.Fprintf(w, "%s  %s\n", entry.Digest, entry.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the manifest in JSON, which can be read by json.Unmarshal.
+func (m *Manifest) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// VerifyManifest checks the files under dir against the manifest,
+// and returns the error of ErrVerifyMismatch on the first file which differs
+// in size, mode, mtime or digest.
+// The digests are computed by Hash, or by the hash named by Algorithm if Hash is nil.
+func VerifyManifest(dir string, manifest *Manifest) error {
+	newHash := manifest.Hash
+	if newHash == nil {
+		var err error
+		if newHash, err = hashOf(manifest.Algorithm); err != nil {
+			return err
+		}
+	}
+	for _, entry := range manifest.Entries {
+		name := filepath.Join(dir, filepath.FromSlash(entry.Path))
+		info, err := os.Lstat(name)
+		if err != nil {
+			return err
+		}
+		var what string
+		switch {
+		case info.Size() != entry.Size:
+			what = "size"
+		case info.Mode() != entry.Mode:
+			what = "mode"
+		case !info.ModTime().Equal(entry.ModTime):
+			what = "mtime"
+		}
+		if what == "" {
+			sum, err := digestOf(osOpen, name, newHash)
+			if err != nil {
+				return err
+			}
+			if hex.EncodeToString(sum) != entry.Digest {
+				what = "digest"
+			}
+		}
+		if what != "" {
+			return &fs.PathError{Op: "verify", Path: name, Err: fmt.Errorf("%w: %s", ErrVerifyMismatch, what)}
+		}
+	}
+	return nil
+}
+
+// algorithmOf names the hash function, by the digest of nothing.
+func algorithmOf(newHash func() hash.Hash) string {
+	if newHash == nil {
+		return "sha256"
+	}
+	sum := newHash().Sum(nil)
+	switch {
+	case bytes.Equal(sum, SHA256().Sum(nil)):
+		return "sha256"
+	case bytes.Equal(sum, BLAKE2b256().Sum(nil)):
+		return "blake2b-256"
+	default:
+		return "custom"
+	}
+}
+
+// hashOf returns the hash function named by algorithmOf.
+// An empty name is SHA256, for manifests written without Algorithm.
+func hashOf(algorithm string) (func() hash.Hash, error) {
+	switch algorithm {
+	case "", "sha256":
+		return SHA256, nil
+	case "blake2b-256":
+		return BLAKE2b256, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnknownAlgorithm, algorithm)
+	}
+}
+
+// digestOf digests the contents of the named file by newHash, or SHA256 if nil.
+func digestOf(open func(name string) (fs.File, error), name string, newHash func() hash.Hash) ([]byte, error) {
+	if newHash == nil {
+		newHash = SHA256
+	}
+	f, err := open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
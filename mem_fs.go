@@ -0,0 +1,571 @@
+package copy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFS is an in-memory tree, which can be both
+// the destination of Copy as Options.DestFS
+// and the source of Copy as Options.FS.
+// It keeps modes, times, symlinks and owners of the entries,
+// but it does NOT check permissions on reading or writing.
+type MemFS struct {
+	mu    sync.RWMutex
+	nodes map[string]*memNode
+}
+
+var errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// MemStat is what Sys() of the FileInfo of MemFS returns.
+type MemStat struct {
+	Uid   int
+	Gid   int
+	Atime time.Time
+}
+
+type memNode struct {
+	mode    fs.FileMode
+	data    []byte
+	target  string
+	modTime time.Time
+	atime   time.Time
+	uid     int
+	gid     int
+}
+
+// NewMemFS creates an empty MemFS, which only has the root directory.
+func NewMemFS() *MemFS {
+	now := time.Now()
+	return &MemFS{nodes: map[string]*memNode{
+		".": {mode: fs.ModeDir | 0o755, modTime: now, atime: now},
+	}}
+}
+
+// memPath converts both of OS path and fs.FS path to the key of the nodes.
+func memPath(name string) string {
+	name = path.Clean(filepath.ToSlash(name))
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func memJoin(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// resolve follows symlinks in name.
+// The last element is followed only if follow is true.
+func (m *MemFS) resolve(name string, follow bool) (string, error) {
+	name = memPath(name)
+	if name == "." {
+		return name, nil
+	}
+	parts := strings.Split(name, "/")
+	cur := "."
+	for i, hops := 0, 0; i < len(parts); i++ {
+		next := memJoin(cur, parts[i])
+		n, ok := m.nodes[next]
+		if !ok || n.mode&fs.ModeSymlink == 0 || (i == len(parts)-1 && !follow) {
+			cur = next
+			continue
+		}
+		if hops++; hops > 40 {
+			return "", errTooManySymlinks
+		}
+		target := filepath.ToSlash(n.target)
+		if path.IsAbs(target) {
+			target = memPath(target)
+		} else {
+			target = memPath(path.Join(cur, target))
+		}
+		parts = append(strings.Split(target, "/"), parts[i+1:]...)
+		cur, i = ".", -1
+		if target == "." {
+			parts = parts[1:]
+		}
+	}
+	return cur, nil
+}
+
+// lookup returns the node of name, with following symlinks if follow is true.
+func (m *MemFS) lookup(op, name string, follow bool) (string, *memNode, error) {
+	key, err := m.resolve(name, follow)
+	if err != nil {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	n, ok := m.nodes[key]
+	if !ok {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return key, n, nil
+}
+
+// parent makes sure that the parent directory of name exists.
+func (m *MemFS) parent(op, name string) (string, error) {
+	key, err := m.resolve(name, false)
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if key == "." {
+		return key, nil
+	}
+	if p, ok := m.nodes[path.Dir(key)]; !ok {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	} else if !p.mode.IsDir() {
+		return "", &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return key, nil
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents.
+func (m *MemFS) MkdirAll(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, true)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if key == "." {
+		return nil
+	}
+	cur := "."
+	for _, p := range strings.Split(key, "/") {
+		cur = memJoin(cur, p)
+		if _, n, err := m.lookup("mkdir", cur, true); err == nil {
+			if !n.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+			}
+			continue
+		}
+		now := time.Now()
+		m.nodes[cur] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: now, atime: now, uid: os.Getuid(), gid: os.Getgid()}
+	}
+	return nil
+}
+
+// OpenFile opens the named file for writing, with the flags of os.OpenFile.
+func (m *MemFS) OpenFile(name string, flag int, perm os.FileMode) (WritableFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, n, err := m.lookup("open", name, true)
+	switch {
+	case err == nil && n.mode.IsDir():
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case err == nil:
+		if flag&os.O_TRUNC != 0 {
+			n.data, n.modTime = nil, time.Now()
+		}
+	case flag&os.O_CREATE == 0:
+		return nil, err
+	default:
+		if key, err = m.parent("open", name); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		n = &memNode{mode: perm.Perm(), modTime: now, atime: now, uid: os.Getuid(), gid: os.Getgid()}
+		m.nodes[key] = n
+	}
+	return &memFile{m: m, node: n, append: flag&os.O_APPEND != 0}, nil
+}
+
+// Create creates or truncates the named file.
+func (m *MemFS) Create(name string) (WritableFile, error) {
+	return m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+}
+
+// Symlink creates newname as a symlink to oldname.
+func (m *MemFS) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.parent("symlink", newname)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.nodes[key]; ok {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	now := time.Now()
+	m.nodes[key] = &memNode{mode: fs.ModeSymlink | 0o777, target: oldname, modTime: now, atime: now, uid: os.Getuid(), gid: os.Getgid()}
+	return nil
+}
+
+// Link creates newname as a hard link to oldname.
+func (m *MemFS) Link(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("link", oldname, false)
+	if err != nil {
+		return err
+	}
+	if n.mode.IsDir() {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrInvalid}
+	}
+	key, err := m.parent("link", newname)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.nodes[key]; ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	m.nodes[key] = n
+	return nil
+}
+
+// Mkfifo creates a named pipe, which can not be opened.
+func (m *MemFS) Mkfifo(name string, mode os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.parent("mkfifo", name)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.nodes[key]; ok {
+		return &fs.PathError{Op: "mkfifo", Path: name, Err: fs.ErrExist}
+	}
+	now := time.Now()
+	m.nodes[key] = &memNode{mode: fs.ModeNamedPipe | mode.Perm(), modTime: now, atime: now, uid: os.Getuid(), gid: os.Getgid()}
+	return nil
+}
+
+// Chmod changes the permission bits of the named file.
+func (m *MemFS) Chmod(name string, mode os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	n.mode = n.mode.Type() | mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)
+	return nil
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (m *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	n.atime, n.modTime = atime, mtime
+	return nil
+}
+
+// Lchtimes is the same as Chtimes, but does not follow symlink.
+func (m *MemFS) Lchtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("lchtimes", name, false)
+	if err != nil {
+		return err
+	}
+	n.atime, n.modTime = atime, mtime
+	return nil
+}
+
+// Chown changes the uid and gid of the named file.
+func (m *MemFS) Chown(name string, uid, gid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("chown", name, true)
+	if err != nil {
+		return err
+	}
+	n.uid, n.gid = uid, gid
+	return nil
+}
+
+// Lstat returns the FileInfo of the named file, without following symlink.
+func (m *MemFS) Lstat(name string) (os.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, n, err := m.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return newMemFileInfo(key, n), nil
+}
+
+// Stat returns the FileInfo of the named file.
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, n, err := m.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return newMemFileInfo(key, n), nil
+}
+
+// ReadLink returns the destination of the named symlink.
+func (m *MemFS) ReadLink(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, n, err := m.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.target, nil
+}
+
+// RemoveAll removes path and any children it contains.
+func (m *MemFS) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, err := m.resolve(name, false)
+	if err != nil {
+		return &fs.PathError{Op: "removeall", Path: name, Err: err}
+	}
+	if key == "." {
+		return &fs.PathError{Op: "removeall", Path: name, Err: fs.ErrInvalid}
+	}
+	for k := range m.nodes {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			delete(m.nodes, k)
+		}
+	}
+	return nil
+}
+
+// Rename moves oldpath to newpath, along with its children.
+// If newpath is an existing file or an empty directory, it is replaced.
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	from, n, err := m.lookup("rename", oldpath, false)
+	if err != nil {
+		return err
+	}
+	to, err := m.parent("rename", newpath)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+	if from == "." || strings.HasPrefix(to, from+"/") {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrInvalid}
+	}
+	if existing, ok := m.nodes[to]; ok {
+		switch {
+		case existing.mode.IsDir() && !n.mode.IsDir():
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EISDIR}
+		case !existing.mode.IsDir() && n.mode.IsDir():
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.ENOTDIR}
+		case existing.mode.IsDir() && len(m.readDir(to)) != 0:
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrExist}
+		}
+	}
+	moved := map[string]*memNode{}
+	for k, n := range m.nodes {
+		if k == from || strings.HasPrefix(k, from+"/") {
+			moved[to+strings.TrimPrefix(k, from)] = n
+			delete(m.nodes, k)
+		}
+	}
+	for k, n := range moved {
+		m.nodes[k] = n
+	}
+	return nil
+}
+
+// Exchange swaps oldpath and newpath atomically, along with their children.
+func (m *MemFS) Exchange(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, _, err := m.lookup("exchange", oldpath, false)
+	if err != nil {
+		return err
+	}
+	b, _, err := m.lookup("exchange", newpath, false)
+	if err != nil {
+		return err
+	}
+	if a == "." || b == "." || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/") {
+		return &os.LinkError{Op: "exchange", Old: oldpath, New: newpath, Err: fs.ErrInvalid}
+	}
+	moved := map[string]*memNode{}
+	for k, n := range m.nodes {
+		switch {
+		case k == a || strings.HasPrefix(k, a+"/"):
+			moved[b+strings.TrimPrefix(k, a)] = n
+			delete(m.nodes, k)
+		case k == b || strings.HasPrefix(k, b+"/"):
+			moved[a+strings.TrimPrefix(k, b)] = n
+			delete(m.nodes, k)
+		}
+	}
+	for k, n := range moved {
+		m.nodes[k] = n
+	}
+	return nil
+}
+
+// Open opens the named file for reading, as fs.FS.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return m.open(name)
+}
+
+// open is the same as Open, but accepts OS paths as OpenFile does,
+// so that Copy can read back what it wrote into DestFS.
+func (m *MemFS) open(name string) (fs.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, n, err := m.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info := newMemFileInfo(key, n)
+	if n.mode.IsDir() {
+		return &memDir{info: info, entries: m.readDir(key)}, nil
+	}
+	if !n.mode.IsRegular() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	data := append([]byte(nil), n.data...)
+	return &memReader{info: info, Reader: bytes.NewReader(data)}, nil
+}
+
+// ReadDir reads the named directory, as fs.ReadDirFS.
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, n, err := m.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	return m.readDir(key), nil
+}
+
+// readDir lists the children of dir, sorted by name.
+func (m *MemFS) readDir(dir string) []fs.DirEntry {
+	entries := []fs.DirEntry{}
+	for k, n := range m.nodes {
+		if k != "." && path.Dir(k) == dir {
+			entries = append(entries, fs.FileInfoToDirEntry(newMemFileInfo(k, n)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries
+}
+
+// memFileInfo is a snapshot of memNode.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	sys     *MemStat
+}
+
+func newMemFileInfo(key string, n *memNode) *memFileInfo {
+	size := int64(len(n.data))
+	if n.mode&fs.ModeSymlink != 0 {
+		size = int64(len(n.target))
+	}
+	return &memFileInfo{
+		name:    path.Base(key),
+		size:    size,
+		mode:    n.mode,
+		modTime: n.modTime,
+		sys:     &MemStat{Uid: n.uid, Gid: n.gid, Atime: n.atime},
+	}
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memFileInfo) Sys() interface{}   { return i.sys }
+
+// memFile is a file of MemFS opened for writing.
+type memFile struct {
+	m      *MemFS
+	node   *memNode
+	off    int64
+	append bool
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	if f.append {
+		f.off = int64(len(f.node.data))
+	}
+	if end := f.off + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	n := copy(f.node.data[f.off:], p)
+	f.off += int64(n)
+	f.node.modTime = time.Now()
+	return n, nil
+}
+
+func (f *memFile) Close() error { return nil }
+func (f *memFile) Sync() error  { return nil }
+
+// memReader is a file of MemFS opened for reading.
+type memReader struct {
+	info *memFileInfo
+	*bytes.Reader
+}
+
+func (f *memReader) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memReader) Close() error               { return nil }
+
+// memDir is a directory of MemFS opened for reading.
+type memDir struct {
+	info    *memFileInfo
+	entries []fs.DirEntry
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: syscall.EISDIR}
+}
+
+func (d *memDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
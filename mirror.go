@@ -0,0 +1,131 @@
+package copy
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// MirrorMode represents when to delete dest entries which are not in src, as "rsync --delete" does.
+type MirrorMode int
+
+const (
+	// MirrorNever deletes nothing (default behavior).
+	MirrorNever MirrorMode = iota
+	// MirrorDeleteAfter deletes them after the contents of each directory are copied.
+	MirrorDeleteAfter
+	// MirrorDeleteBefore deletes them before the contents of each directory are copied,
+	// e.g. to make room on the dest filesystem.
+	MirrorDeleteBefore
+)
+
+var errMirrorUnsupported = errors.New("mirror needs DestFS which can read directories")
+
+// extraneous lists the entries in destdir which have no counterpart in srcdir.
+// Entries excluded by Skip are protected, unless MirrorDeleteSkipped is true.
+// Backups made by Backup or BackupName are protected as well.
+func extraneous(srcdir, destdir string, contents []os.FileInfo, opt Options) ([]os.FileInfo, error) {
+	destfs, ok := opt.DestFS.(readDirFS)
+	if !ok {
+		return nil, &fs.PathError{Op: "mirror", Path: destdir, Err: errMirrorUnsupported}
+	}
+	entries, err := destfs.ReadDir(destdir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	keep := map[string]bool{}
+	for _, info := range contents {
+		cs, cd := filepath.Join(srcdir, info.Name()), filepath.Join(destdir, info.Name())
+		if opt.MirrorDeleteSkipped && opt.Skip != nil {
+			if skip, err := opt.Skip(info, cs, cd); err != nil {
+				return nil, err
+			} else if skip {
+				continue
+			}
+		}
+		if opt.RenameDestination != nil {
+			if cd, err = opt.RenameDestination(cs, cd); err != nil {
+				return nil, err
+			}
+		}
+		if info.Mode().IsRegular() {
+			if cd, _, err = transformedDest(cs, cd, info, opt); err != nil {
+				return nil, err
+			}
+		}
+		keep[cd] = true
+	}
+
+	// Backups are not extraneous, otherwise they would be backed up again.
+	// Those by BackupName are found by naming the backups of the entries.
+	if opt.BackupName != nil {
+		names := []string{}
+		for cd := range keep {
+			names = append(names, cd)
+		}
+		for _, e := range entries {
+			names = append(names, filepath.Join(destdir, e.Name()))
+		}
+		for _, cd := range names {
+			name, err := opt.BackupName(cd)
+			if err != nil {
+				return nil, err
+			}
+			keep[name] = true
+		}
+	}
+
+	extras := []os.FileInfo{}
+	for _, e := range entries {
+		cd := filepath.Join(destdir, e.Name())
+		if keep[cd] || (backups(opt) && isBackup(e.Name(), opt)) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		if !opt.MirrorDeleteSkipped && opt.Skip != nil {
+			if skip, err := opt.Skip(info, filepath.Join(srcdir, e.Name()), cd); err != nil {
+				return nil, err
+			} else if skip {
+				continue
+			}
+		}
+		extras = append(extras, info)
+	}
+	return extras, nil
+}
+
+// mirror deletes the entries in destdir which have no counterpart in srcdir.
+// If Backup or BackupName is given, they are backed up instead.
+func mirror(srcdir, destdir string, contents []os.FileInfo, opt Options) error {
+	extras, err := extraneous(srcdir, destdir, contents, opt)
+	if err != nil {
+		return err
+	}
+	for _, info := range extras {
+		cs, cd := filepath.Join(srcdir, info.Name()), filepath.Join(destdir, info.Name())
+		if backups(opt) {
+			err = backup(cd, opt)
+		} else {
+			err = opt.DestFS.RemoveAll(cd)
+		}
+		if err != nil {
+			if err := onError(cs, cd, err, opt); err != nil {
+				return err
+			}
+			continue
+		}
+		opt.intent.report.deleted()
+		if opt.OnDelete != nil {
+			opt.OnDelete(cd, info)
+		}
+	}
+	return nil
+}
@@ -2,9 +2,11 @@ package copy
 
 import (
 	"context"
+	"hash"
 	"io"
 	"io/fs"
 	"os"
+	"time"
 
 	"golang.org/x/sync/semaphore"
 )
@@ -18,6 +20,90 @@ type Options struct {
 	// OnDirExists can specify what to do when there is a directory already existing in destination.
 	OnDirExists func(src, dest string) DirExistsAction
 
+	// OnFileExists can specify what to do when there is a file already existing in destination.
+	// If nil, the file is overwritten. If not nil, new files are created with O_EXCL,
+	// so that files created by others meanwhile are not clobbered, except with Atomic.
+	OnFileExists func(src, dest string, srcinfo, destinfo os.FileInfo) FileExistsAction
+
+	// Backup renames files and symlinks in destination before they are overwritten,
+	// and directories before they are replaced by OnDirExists, as "cp --backup" does.
+	// BackupNone is default.
+	Backup BackupMode
+
+	// BackupSuffix is the suffix for BackupSimple. If empty, "~" is used.
+	BackupSuffix string
+
+	// BackupName can name the backup of dest by yourself, instead of Backup.
+	BackupName func(dest string) (string, error)
+
+	// Update skips files which are unchanged from dest,
+	// by comparing the size and the mtime, or the contents.
+	// Files transformed by Transform are always copied, because dest differs from src.
+	// UpdateNever is default.
+	Update UpdateMode
+
+	// UpdateTolerance is the mtime difference regarded as the same by UpdateSizeAndTime,
+	// for filesystems with coarse timestamps, e.g. 2 seconds for FAT.
+	UpdateTolerance time.Duration
+
+	// Mirror deletes entries in dest directories which are not in src, as "rsync --delete" does.
+	// Entries excluded by Skip are kept, unless MirrorDeleteSkipped is true.
+	// If Backup or BackupName is given, they are backed up instead of deleted,
+	// and the backups already in dest are left as they are.
+	// Backups by BackupName are found only if the entries backed up are still in src or dest.
+	// MirrorNever is default.
+	Mirror MirrorMode
+
+	// MirrorDeleteSkipped lets Mirror delete entries excluded by Skip too.
+	MirrorDeleteSkipped bool
+
+	// OnDelete is called every time Mirror deletes an entry.
+	// It can be called concurrently if NumOfWorkers > 1.
+	// Errors on deleting go to OnError.
+	OnDelete func(dest string, info os.FileInfo)
+
+	// Verify reads each dest file back after it's copied (and synced if Sync),
+	// and compares its digest with the digest of the src bytes computed while copying.
+	// A mismatch is an error of ErrVerifyMismatch, which goes to OnError.
+	// Because the bytes are read by Go, CopyFileRange is not used.
+	// Files cloned by Reflink are read once more to be digested.
+	Verify bool
+
+	// Hash is the hash function for Verify, Manifest and UpdateContent, e.g. SHA256, BLAKE2b256,
+	// or your own. SHA256 is default.
+	Hash func() hash.Hash
+
+	// Manifest is filled with the path, the size, the mode, the mtime and the digest
+	// of every file copied, if given. The digest is computed while copying,
+	// which disables CopyFileRange as Verify does.
+	// It can be written by Manifest.WriteSums and Manifest.WriteJSON,
+	// and checked later by VerifyManifest.
+	Manifest *Manifest
+
+	// Resume writes each file into a hidden partial file next to dest, ".name.partial",
+	// which is left when the copy is interrupted and renamed to dest when finished.
+	// The next copy appends to the partial file, after checking it against src
+	// by the digests of the tail blocks or the whole, instead of starting over.
+	// ResumeNever is default. Atomic is not needed with Resume.
+	Resume ResumeMode
+
+	// RateLimit limits the bytes per second read from src files in total,
+	// shared by all workers even if NumOfWorkers > 1. If 0, it's unlimited.
+	// CopyFileRange is not used with RateLimit, while Reflink is, as it copies no bytes.
+	RateLimit int64
+
+	// RateLimitOps limits the entries per second created in dest in total,
+	// for trees with many small files. If 0, it's unlimited.
+	RateLimitOps int64
+
+	// Transform can transform the contents of each file while copying,
+	// by wrapping the src reader and the dest writer, and can rename dest,
+	// e.g. GzipCompress, GzipDecompress, ZlibCompress, ZlibDecompress and NormalizeCRLF.
+	// Verify and Manifest digest what is written into dest, and Resume is not used,
+	// for the transformed files. Report.Bytes counts the bytes written into dest,
+	// while OnProgress counts the bytes read from src, as PreScan totals them.
+	Transform TransformFunc
+
 	// OnErr lets called decide whether or not to continue on particular copy error.
 	OnError func(src, dest string, err error) error
 
@@ -30,6 +116,11 @@ type Options struct {
 	// Specials includes special files to be copied. default false.
 	Specials bool
 
+	// OnSpecial can specify what to do on device files and sockets.
+	// If nil, devices are read as regular files only when Specials is true,
+	// and sockets are skipped.
+	OnSpecial func(src string, info os.FileInfo) SpecialAction
+
 	// AddPermission to every entities,
 	// NO MORE THAN 0777
 	// @OBSOLETE
@@ -65,6 +156,8 @@ type Options struct {
 	// If you want to add some limitation on reading src file,
 	// you can wrap the src and provide new reader,
 	// such as `RateLimitReader` in the test case.
+	// @OBSOLETE
+	// Use `Transform` instead, which knows the file and can wrap dest too.
 	WrapReader func(src io.Reader) io.Reader
 
 	// If given, copy.Copy refers to this fs.FS instead of the OS filesystem.
@@ -84,6 +177,65 @@ type Options struct {
 	// If NumOfWorkers is 0 or 1, this function will be ignored.
 	PreferConcurrent func(srcdir, destdir string) (bool, error)
 
+	// OnProgress is called every time some bytes are copied
+	// and every time a file is finished.
+	// It is never called concurrently, even if NumOfWorkers > 1.
+	OnProgress func(p Progress)
+
+	// PreScan plans the copy before copying, as Plan does,
+	// so that OnProgress can know TotalBytes and TotalFiles.
+	// Errors found by planning go to OnError only once, when the copy meets them.
+	// If OnProgress is nil, this option will be ignored.
+	PreScan bool
+
+	// If given, copy.Copy writes into this WritableFS instead of the OS filesystem.
+	// e.g., You can copy files into in-memory tree, or into a prefixed sandbox.
+	DestFS WritableFS
+
+	// Atomic writes each file into a hidden temporary file next to dest,
+	// and renames it over dest only after everything succeeded,
+	// so that dest is never seen half-written.
+	Atomic bool
+
+	// AtomicTree copies a directory into a hidden staging directory next to dest,
+	// and swaps it with dest only after everything succeeded,
+	// so that dest is seen either as the complete old tree or the complete new tree.
+	// The old tree is removed after the swap, thus OnDirExists is not consulted.
+	// On Linux, they are swapped by renameat2(RENAME_EXCHANGE),
+	// otherwise dest is renamed aside for a moment.
+	// Because the staging directory starts empty, Update, Mirror, OnFileExists,
+	// Backup and Resume see no existing files there, and do nothing to the old tree.
+	AtomicTree bool
+
+	// Reflink clones files by copy-on-write, e.g., on btrfs and XFS,
+	// instead of copying the bytes. Only supported on Linux.
+	// ReflinkNever is default.
+	Reflink ReflinkMode
+
+	// CopyFileRange copies files in the kernel by copy_file_range(2) on Linux,
+	// falling back to the loop where it can not be used.
+	// It is not used if WrapReader, CopyBufferSize, Verify or Manifest is given.
+	// Report.CopyFileRange tells how many files were copied by it.
+	CopyFileRange bool
+
+	// Sparse makes holes in dest files, instead of writing zeros,
+	// for such as disk images. SparseNever is default.
+	Sparse SparseMode
+
+	// PreserveHardLinks links files which are hard links to the same file in src,
+	// instead of copying them again.
+	PreserveHardLinks bool
+
+	// PreserveXattrs copies the extended attributes in the namespaces,
+	// e.g. XattrUser | XattrACL, of files, directories and symlinks.
+	// The ACL is kept consistent with the mode made by PermissionControl.
+	// Only on Linux, and only when FS is nil, for now.
+	PreserveXattrs XattrNamespace
+
+	// OnXattr can drop or rewrite each extended attribute before it's set.
+	// If it returns false, the attribute is not copied.
+	OnXattr func(src, name string, value []byte) ([]byte, bool)
+
 	// Internal use only
 	intent intent
 }
@@ -93,6 +245,12 @@ type intent struct {
 	dest string
 	sem  *semaphore.Weighted
 	ctx  context.Context
+
+	progress  *progress
+	report    *Report
+	hardlinks *hardlinks
+	bytes     *bucket
+	ops       *bucket
 }
 
 // SymlinkAction represents what to do on symlink.
@@ -119,6 +277,40 @@ const (
 	Untouchable
 )
 
+// FileExistsAction represents what to do on existing dest file.
+type FileExistsAction int
+
+const (
+	// FileOverwrite truncates and overwrites the file (default behavior).
+	FileOverwrite FileExistsAction = iota
+	// FileSkip does nothing for the file, and leaves it as it is.
+	FileSkip
+	// FileError fails with ErrExists.
+	FileError
+	// FileOverwriteIfNewer overwrites the file only if src is modified after it.
+	FileOverwriteIfNewer
+	// FileOverwriteIfDifferentSize overwrites the file only if the size differs from src.
+	FileOverwriteIfDifferentSize
+	// FileForceOverwrite overwrites the file, and removes it first if it cannot be opened,
+	// e.g., because it's read-only.
+	FileForceOverwrite
+)
+
+// SpecialAction represents what to do on device files and sockets.
+type SpecialAction int
+
+const (
+	// SpecialSkip does nothing with the file.
+	SpecialSkip SpecialAction = iota
+	// SpecialRecreate creates the device node or the socket by mknod,
+	// with the same major and minor numbers. Only supported on Linux.
+	SpecialRecreate
+	// SpecialRead reads the contents of the file as a regular file.
+	SpecialRead
+	// SpecialError fails with ErrSpecialFile.
+	SpecialError
+)
+
 // getDefaultOptions provides default options,
 // which would be modified by usage-side.
 func getDefaultOptions(src, dest string) Options {
@@ -136,7 +328,8 @@ func getDefaultOptions(src, dest string) Options {
 		PreserveTimes:     false,              // Do not preserve the modification time
 		CopyBufferSize:    0,                  // Do not specify, use default bufsize (32*1024)
 		WrapReader:        nil,                // Do not wrap src files, use them as they are.
-		intent:            intent{src, dest, nil, nil},
+		DestFS:            OSFS,               // Write into the OS filesystem
+		intent:            intent{src, dest, nil, context.Background(), nil, nil, nil, nil, nil},
 	}
 }
 
@@ -153,13 +346,20 @@ func assureOptions(src, dest string, opts ...Options) Options {
 	if opts[0].Skip == nil {
 		opts[0].Skip = defopt.Skip
 	}
+	if opts[0].DestFS == nil {
+		opts[0].DestFS = defopt.DestFS
+	}
 	if opts[0].AddPermission > 0 {
 		opts[0].PermissionControl = AddPermission(opts[0].AddPermission)
 	} else if opts[0].PermissionControl == nil {
 		opts[0].PermissionControl = PerservePermission
 	}
+	if opts[0].Update == UpdateSizeAndTime {
+		opts[0].PreserveTimes = true
+	}
 	opts[0].intent.src = defopt.intent.src
 	opts[0].intent.dest = defopt.intent.dest
+	opts[0].intent.ctx = defopt.intent.ctx
 	return opts[0]
 }
 
@@ -18,20 +18,21 @@ var (
 	AddPermission = func(perm os.FileMode) PermissionControlFunc {
 		return func(srcinfo fs.FileInfo, dest string) (func(*error), error) {
 			orig := srcinfo.Mode()
+			destfs := destFSOf(srcinfo)
 			if srcinfo.IsDir() {
-				if err := os.MkdirAll(dest, tmpPermissionForDirectory); err != nil {
+				if err := destfs.MkdirAll(dest, tmpPermissionForDirectory); err != nil {
 					return func(*error) {}, err
 				}
 			}
 			return func(err *error) {
-				chmod(dest, orig|perm, err)
+				chmod(destfs, dest, orig|perm, err)
 			}, nil
 		}
 	}
 	PerservePermission PermissionControlFunc = AddPermission(0)
 	DoNothing          PermissionControlFunc = func(srcinfo fs.FileInfo, dest string) (func(*error), error) {
 		if srcinfo.IsDir() {
-			if err := os.MkdirAll(dest, srcinfo.Mode()); err != nil {
+			if err := destFSOf(srcinfo).MkdirAll(dest, srcinfo.Mode()); err != nil {
 				return func(*error) {}, err
 			}
 		}
@@ -42,8 +43,8 @@ var (
 // chmod ANYHOW changes file mode,
 // with assigning error raised during Chmod,
 // BUT respecting the error already reported.
-func chmod(dir string, mode os.FileMode, reported *error) {
-	if err := os.Chmod(dir, mode); *reported == nil {
+func chmod(destfs WritableFS, dir string, mode os.FileMode, reported *error) {
+	if err := destfs.Chmod(dir, mode); *reported == nil {
 		*reported = err
 	}
 }
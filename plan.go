@@ -0,0 +1,291 @@
+package copy
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OperationType represents what Copy would do on an entry.
+type OperationType int
+
+const (
+	// OpMkdir creates a directory.
+	OpMkdir OperationType = iota
+	// OpCopyFile copies the contents of a file.
+	OpCopyFile
+	// OpSymlink creates a symlink.
+	OpSymlink
+	// OpMkfifo creates a named pipe.
+	OpMkfifo
+	// OpReplaceDir deletes the existing directory and creates it again.
+	OpReplaceDir
+	// OpSkip does nothing with the entry, see Operation.Reason.
+	OpSkip
+	// OpLink creates a hard link to Operation.Link.
+	OpLink
+	// OpMknod creates a device node or a socket.
+	OpMknod
+	// OpDelete deletes the dest entry which is not in src, by Mirror.
+	OpDelete
+)
+
+func (t OperationType) String() string {
+	switch t {
+	case OpMkdir:
+		return "mkdir"
+	case OpCopyFile:
+		return "copy"
+	case OpSymlink:
+		return "symlink"
+	case OpMkfifo:
+		return "mkfifo"
+	case OpReplaceDir:
+		return "replace"
+	case OpSkip:
+		return "skip"
+	case OpLink:
+		return "link"
+	case OpMknod:
+		return "mknod"
+	case OpDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// Operation represents one step which Copy would take.
+type Operation struct {
+	Type OperationType
+	Src  string
+	Dest string
+
+	// Size is the byte size of src, only for OpCopyFile.
+	Size int64
+
+	// Reason tells why the entry is skipped, only for OpSkip.
+	Reason string
+
+	// Link is the dest which is linked to, only for OpLink.
+	Link string
+}
+
+// Plan goes through the same decisions as Copy without writing anything,
+// and returns the operations in the order Copy would take them.
+func Plan(src, dest string, opts ...Options) ([]Operation, error) {
+	opt := assureOptions(src, dest, opts...)
+	var info os.FileInfo
+	var err error
+	if _, ok := opt.FS.(readlinkFS); ok {
+		info, err = lstat(src, opt)
+	} else if opt.FS != nil {
+		info, err = fs.Stat(opt.FS, src)
+	} else {
+		info, err = os.Lstat(src)
+	}
+	if err != nil {
+		return nil, onError(src, dest, err, opt)
+	}
+	opt.intent.hardlinks = newHardlinks(opt)
+	ops := []Operation{}
+	err = planSwitchboard(src, dest, info, opt, &ops)
+	return ops, err
+}
+
+// planSwitchboard is the dry-run counterpart of switchboard.
+func planSwitchboard(src, dest string, info os.FileInfo, opt Options, ops *[]Operation) (err error) {
+	action := onSpecial(src, info, opt)
+	switch action {
+	case SpecialSkip:
+		*ops = append(*ops, Operation{Type: OpSkip, Src: src, Dest: dest, Reason: "special file"})
+		return nil
+	case SpecialError:
+		return onError(src, dest, &fs.PathError{Op: "copy", Path: src, Err: ErrSpecialFile}, opt)
+	}
+
+	if opt.RenameDestination != nil {
+		if dest, err = opt.RenameDestination(src, dest); err != nil {
+			return onError(src, dest, err, opt)
+		}
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		err = planSymlink(src, dest, opt, ops)
+	case info.IsDir():
+		err = planDir(src, dest, opt, ops)
+	case info.Mode()&os.ModeNamedPipe != 0:
+		*ops = append(*ops, Operation{Type: OpMkfifo, Src: src, Dest: dest})
+	case action == SpecialRecreate:
+		*ops = append(*ops, Operation{Type: OpMknod, Src: src, Dest: dest})
+	default:
+		var tf *Transform
+		if dest, tf, err = transformedDest(src, dest, info, opt); err != nil {
+			return onError(src, dest, err, opt)
+		}
+		// The inode is registered whether or not it's written, as fcopyOrLink does.
+		first, seen := opt.intent.hardlinks.first(dest, info, opt)
+		if yes, err := unchanged(src, dest, info, tf, opt); err != nil {
+			return onError(src, dest, err, opt)
+		} else if yes {
+			*ops = append(*ops, Operation{Type: OpSkip, Src: src, Dest: dest, Reason: "Update"})
+			return nil
+		}
+		if yes, err := shouldWrite(src, dest, info, opt); err != nil {
+			return onError(src, dest, err, opt)
+		} else if !yes {
+			*ops = append(*ops, Operation{Type: OpSkip, Src: src, Dest: dest, Reason: "OnFileExists"})
+			return nil
+		}
+		if seen {
+			*ops = append(*ops, Operation{Type: OpLink, Src: src, Dest: dest, Link: first.dest})
+		} else {
+			*ops = append(*ops, Operation{Type: OpCopyFile, Src: src, Dest: dest, Size: info.Size()})
+		}
+	}
+
+	return onError(src, dest, err, opt)
+}
+
+// planNextOrSkip is the dry-run counterpart of copyNextOrSkip.
+func planNextOrSkip(src, dest string, info os.FileInfo, opt Options, ops *[]Operation) error {
+	if opt.Skip != nil {
+		skip, err := opt.Skip(info, src, dest)
+		if err != nil {
+			return err
+		}
+		if skip {
+			*ops = append(*ops, Operation{Type: OpSkip, Src: src, Dest: dest, Reason: "Skip"})
+			return nil
+		}
+	}
+	return planSwitchboard(src, dest, info, opt, ops)
+}
+
+// planDir is the dry-run counterpart of dcopy.
+func planDir(srcdir, destdir string, opt Options, ops *[]Operation) error {
+	_, err := opt.DestFS.Lstat(destdir)
+	switch {
+	case err == nil && opt.OnDirExists != nil && destdir != opt.intent.dest:
+		switch opt.OnDirExists(srcdir, destdir) {
+		case Replace:
+			*ops = append(*ops, Operation{Type: OpReplaceDir, Src: srcdir, Dest: destdir})
+			// Nothing is left in destdir once it's replaced.
+			opt.DestFS = replacedFS{opt.DestFS, destdir}
+		case Untouchable:
+			*ops = append(*ops, Operation{Type: OpSkip, Src: srcdir, Dest: destdir, Reason: "Untouchable"})
+			return nil
+		default:
+			*ops = append(*ops, Operation{Type: OpMkdir, Src: srcdir, Dest: destdir})
+		}
+	case err != nil && !os.IsNotExist(err):
+		return err
+	default:
+		*ops = append(*ops, Operation{Type: OpMkdir, Src: srcdir, Dest: destdir})
+	}
+
+	var entries []fs.DirEntry
+	if opt.FS != nil {
+		entries, err = fs.ReadDir(opt.FS, srcdir)
+	} else {
+		entries, err = os.ReadDir(srcdir)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	contents := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		contents = append(contents, info)
+	}
+
+	if opt.Mirror == MirrorDeleteBefore {
+		if err := planMirror(srcdir, destdir, contents, opt, ops); err != nil {
+			return err
+		}
+	}
+	for _, info := range contents {
+		cs, cd := filepath.Join(srcdir, info.Name()), filepath.Join(destdir, info.Name())
+		if err := planNextOrSkip(cs, cd, info, opt, ops); err != nil {
+			return err
+		}
+	}
+	if opt.Mirror == MirrorDeleteAfter {
+		return planMirror(srcdir, destdir, contents, opt, ops)
+	}
+	return nil
+}
+
+// planMirror is the dry-run counterpart of mirror.
+func planMirror(srcdir, destdir string, contents []os.FileInfo, opt Options, ops *[]Operation) error {
+	extras, err := extraneous(srcdir, destdir, contents, opt)
+	if err != nil {
+		return err
+	}
+	for _, info := range extras {
+		*ops = append(*ops, Operation{Type: OpDelete, Src: filepath.Join(srcdir, info.Name()), Dest: filepath.Join(destdir, info.Name())})
+	}
+	return nil
+}
+
+// planSymlink is the dry-run counterpart of onsymlink.
+func planSymlink(src, dest string, opt Options, ops *[]Operation) error {
+	switch opt.OnSymlink(src) {
+	case Shallow:
+		*ops = append(*ops, Operation{Type: OpSymlink, Src: src, Dest: dest})
+		return nil
+	case Deep:
+		orig, err := readlink(src, opt)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(orig) {
+			orig = filepath.Join(filepath.Dir(src), orig)
+		}
+		info, err := lstat(orig, opt)
+		if err != nil {
+			return err
+		}
+		return planNextOrSkip(orig, dest, info, opt, ops)
+	default:
+		*ops = append(*ops, Operation{Type: OpSkip, Src: src, Dest: dest, Reason: "OnSymlink"})
+		return nil
+	}
+}
+
+// replacedFS is the dest seen by Plan after dir is planned to be replaced,
+// in which nothing exists under dir. It's never written.
+type replacedFS struct {
+	WritableFS
+	dir string
+}
+
+func (r replacedFS) replaced(name string) bool {
+	return name == r.dir || strings.HasPrefix(name, r.dir+string(filepath.Separator))
+}
+
+func (r replacedFS) Lstat(name string) (os.FileInfo, error) {
+	if r.replaced(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	return r.WritableFS.Lstat(name)
+}
+
+func (r replacedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if r.replaced(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	if destfs, ok := r.WritableFS.(readDirFS); ok {
+		return destfs.ReadDir(name)
+	}
+	return nil, &fs.PathError{Op: "readdir", Path: name, Err: errMirrorUnsupported}
+}
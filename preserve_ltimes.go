@@ -3,6 +3,8 @@
 package copy
 
 import (
+	"time"
+
 	"golang.org/x/sys/unix"
 )
 
@@ -17,3 +19,10 @@ func preserveLtimes(src, dest string) error {
 		unix.NsecToTimeval(info.Mtim.Nano()),
 	})
 }
+
+func (osFS) Lchtimes(name string, atime, mtime time.Time) error {
+	return unix.Lutimes(name, []unix.Timeval{
+		unix.NsecToTimeval(atime.UnixNano()),
+		unix.NsecToTimeval(mtime.UnixNano()),
+	})
+}
@@ -8,16 +8,17 @@ import (
 	"syscall"
 )
 
-func preserveOwner(src, dest string, info fs.FileInfo) (err error) {
+func preserveOwner(src, dest string, info fs.FileInfo, destfs WritableFS) (err error) {
 	if info == nil {
 		if info, err = os.Stat(src); err != nil {
 			return err
 		}
 	}
-	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-		if err := os.Chown(dest, int(stat.Uid), int(stat.Gid)); err != nil {
-			return err
-		}
+	switch stat := info.Sys().(type) {
+	case *syscall.Stat_t:
+		return destfs.Chown(dest, int(stat.Uid), int(stat.Gid))
+	case *MemStat:
+		return destfs.Chown(dest, stat.Uid, stat.Gid)
 	}
 	return nil
 }
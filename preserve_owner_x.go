@@ -4,6 +4,9 @@ package copy
 
 import "io/fs"
 
-func preserveOwner(src, dest string, info fs.FileInfo) (err error) {
+func preserveOwner(src, dest string, info fs.FileInfo, destfs WritableFS) (err error) {
+	if stat, ok := info.Sys().(*MemStat); ok {
+		return destfs.Chown(dest, stat.Uid, stat.Gid)
+	}
 	return nil
 }
@@ -2,10 +2,18 @@ package copy
 
 import "os"
 
-func preserveTimes(srcinfo os.FileInfo, dest string) error {
-	spec := getTimeSpec(srcinfo)
-	if err := os.Chtimes(dest, spec.Atime, spec.Mtime); err != nil {
+func preserveTimes(srcinfo os.FileInfo, dest string, destfs WritableFS) error {
+	spec := timeSpecOf(srcinfo)
+	if err := destfs.Chtimes(dest, spec.Atime, spec.Mtime); err != nil {
 		return err
 	}
 	return nil
 }
+
+// timeSpecOf is getTimeSpec which also knows the FileInfo of MemFS.
+func timeSpecOf(info os.FileInfo) timespec {
+	if stat, ok := info.Sys().(*MemStat); ok {
+		return timespec{Mtime: info.ModTime(), Atime: stat.Atime, Ctime: info.ModTime()}
+	}
+	return getTimeSpec(info)
+}
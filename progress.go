@@ -0,0 +1,100 @@
+package copy
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Progress represents how far the copy has gone.
+type Progress struct {
+	// Path is the src path of the file which is being copied.
+	Path string
+
+	// CopiedBytes is the number of bytes copied so far.
+	CopiedBytes int64
+
+	// CopiedFiles is the number of files finished so far.
+	CopiedFiles int64
+
+	// TotalBytes is the number of bytes to be copied,
+	// found by scanning src before copying.
+	// It is always 0 unless Options.PreScan is true.
+	TotalBytes int64
+
+	// TotalFiles is the number of files to be copied,
+	// found by scanning src before copying.
+	// It is always 0 unless Options.PreScan is true.
+	TotalFiles int64
+}
+
+// progress is shared by all the fcopy calls of one Copy,
+// even when they run concurrently under NumOfWorkers.
+type progress struct {
+	mu         sync.Mutex
+	current    Progress
+	onProgress func(Progress)
+}
+
+func newProgress(opt Options) *progress {
+	if opt.OnProgress == nil {
+		return nil
+	}
+	return &progress{onProgress: opt.OnProgress}
+}
+
+// add counts n bytes of path, and notifies the caller.
+// OnProgress is never called concurrently.
+func (p *progress) add(path string, n int64, done bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current.Path = path
+	p.current.CopiedBytes += n
+	if done {
+		p.current.CopiedFiles++
+	}
+	p.onProgress(p.current)
+}
+
+// progressReader reports every chunk read from src.
+type progressReader struct {
+	p    *progress
+	path string
+	r    io.Reader
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 {
+		r.p.add(r.path, int64(n), false)
+	}
+	return n, err
+}
+
+// prescan plans the copy without copying anything,
+// and sets the totals of the progress.
+// Errors are left to the copy, which reports them to OnError and Report by itself,
+// and the totals are of what is planned until the error.
+func prescan(src, dest string, info os.FileInfo, opt Options) {
+	if opt.intent.progress == nil {
+		return
+	}
+	// Planning must not share the hard links with copying.
+	opt.intent.hardlinks = newHardlinks(opt)
+	opt.intent.report = nil
+	opt.OnError = func(src, dest string, err error) error { return nil }
+	ops := []Operation{}
+	planSwitchboard(src, dest, info, opt, &ops)
+	for _, op := range ops {
+		switch op.Type {
+		case OpCopyFile:
+			opt.intent.progress.current.TotalBytes += op.Size
+			opt.intent.progress.current.TotalFiles++
+		case OpLink:
+			opt.intent.progress.current.TotalFiles++
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package copy
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// bucket is the token bucket shared by all workers of a copy.
+// Taking more tokens than it has is allowed, and the debt is paid by waiting,
+// so that the rate is kept even with large reads.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket creates the bucket which is full of a second of tokens, or nil if rate is 0.
+func newBucket(rate int64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{rate: float64(rate), burst: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait takes n tokens, waiting until they are paid or ctx is done.
+// Nothing happens if the bucket is nil.
+func (b *bucket) wait(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	debt := b.tokens
+	b.mu.Unlock()
+	if debt >= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(-debt / b.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitReader limits the bytes read from all files by the shared bucket.
+type rateLimitReader struct {
+	ctx context.Context
+	b   *bucket
+	r   io.Reader
+}
+
+func (r rateLimitReader) Read(p []byte) (int, error) {
+	// Read no more than the burst, to keep the rate smooth.
+	if len(p) > int(r.b.burst) {
+		p = p[:int(r.b.burst)]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.b.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
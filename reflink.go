@@ -0,0 +1,55 @@
+package copy
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// ReflinkMode represents whether or not to clone files by reflink.
+type ReflinkMode int
+
+const (
+	// ReflinkNever always copies the bytes of files (default behavior).
+	ReflinkNever ReflinkMode = iota
+	// ReflinkAuto tries to clone files, and copies the bytes
+	// if the filesystem does not support cloning.
+	ReflinkAuto
+	// ReflinkAlways clones files, or returns error if it can not.
+	ReflinkAlways
+)
+
+var errReflinkUnsupported = errors.New("reflink is not supported")
+
+// reflink clones srcfile into f by copy-on-write, if requested by opt.
+// It reports false if the bytes should be copied instead.
+// If h is given, srcfile is read into h after cloning, for Verify and Manifest.
+func reflink(f WritableFile, srcfile io.Reader, src string, h hash.Hash, opt Options) (bool, error) {
+	if opt.Reflink == ReflinkNever {
+		return false, nil
+	}
+	d, ok := f.(*os.File)
+	s, ok2 := srcfile.(*os.File)
+	// WrapReader would be bypassed by cloning.
+	if !ok || !ok2 || opt.WrapReader != nil {
+		return reflinkFallback(src, errReflinkUnsupported, opt)
+	}
+	if err := ficlone(d, s); err != nil {
+		return reflinkFallback(src, err, opt)
+	}
+	if h != nil {
+		if _, err := io.Copy(h, s); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func reflinkFallback(src string, err error, opt Options) (bool, error) {
+	if opt.Reflink == ReflinkAuto && (err == errReflinkUnsupported || reflinkUnsupported(err)) {
+		return false, nil
+	}
+	return false, &fs.PathError{Op: "reflink", Path: src, Err: err}
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package copy
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ficlone clones src into dest by ioctl(FICLONE), available on btrfs, XFS and so on.
+func ficlone(dest, src *os.File) error {
+	return unix.IoctlFileClone(int(dest.Fd()), int(src.Fd()))
+}
+
+// reflinkUnsupported tells if ReflinkAuto should fall back to copying the bytes.
+func reflinkUnsupported(err error) bool {
+	return errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EXDEV)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package copy
+
+import "os"
+
+// ficlone is not supported except Linux.
+func ficlone(dest, src *os.File) error {
+	return errReflinkUnsupported
+}
+
+func reflinkUnsupported(err error) bool {
+	return false
+}
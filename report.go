@@ -0,0 +1,90 @@
+package copy
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Report represents what was done by CopyWithReport.
+type Report struct {
+	Files      int64         `json:"files"`
+	Dirs       int64         `json:"dirs"`
+	Symlinks   int64         `json:"symlinks"`
+	NamedPipes int64         `json:"named_pipes"`
+	Specials   int64         `json:"specials"`
+	Skipped    int64         `json:"skipped"`
+	Deleted    int64         `json:"deleted"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"duration"`
+
+	// CopyFileRange is the number of files copied by copy_file_range(2).
+	CopyFileRange int64 `json:"copy_file_range"`
+
+	// HardLinks is the number of files linked by PreserveHardLinks.
+	HardLinks int64 `json:"hard_links"`
+
+	// Resumed is the number of files resumed from partial files by Resume.
+	Resumed int64 `json:"resumed"`
+
+	// Errors are the errors which OnError suppressed.
+	Errors []ReportError `json:"errors"`
+
+	mu sync.Mutex
+}
+
+// ReportError is an error suppressed by OnError, with its paths.
+type ReportError struct {
+	Src  string
+	Dest string
+	Err  error
+}
+
+// MarshalJSON marshals Err as its message.
+func (e ReportError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Src   string `json:"src"`
+		Dest  string `json:"dest"`
+		Error string `json:"error"`
+	}{e.Src, e.Dest, e.Err.Error()})
+}
+
+// CopyWithReport is the same as Copy, but also returns the Report
+// of what was copied, skipped and suppressed.
+func CopyWithReport(src, dest string, opts ...Options) (*Report, error) {
+	opt := assureOptions(src, dest, opts...)
+	report := &Report{Errors: []ReportError{}}
+	opt.intent.report = report
+	begin := time.Now()
+	err := start(src, dest, opt)
+	report.Duration = time.Since(begin)
+	return report, err
+}
+
+// count lets the counter be updated safely,
+// even when the copy runs concurrently.
+// Nothing happens if the report is not requested.
+func (r *Report) count(f func(r *Report)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(r)
+}
+
+func (r *Report) file(n int64) { r.count(func(r *Report) { r.Files++; r.Bytes += n }) }
+func (r *Report) dir()         { r.count(func(r *Report) { r.Dirs++ }) }
+func (r *Report) symlink()     { r.count(func(r *Report) { r.Symlinks++ }) }
+func (r *Report) namedPipe()   { r.count(func(r *Report) { r.NamedPipes++ }) }
+func (r *Report) special()     { r.count(func(r *Report) { r.Specials++ }) }
+func (r *Report) skipped()     { r.count(func(r *Report) { r.Skipped++ }) }
+func (r *Report) deleted()     { r.count(func(r *Report) { r.Deleted++ }) }
+
+func (r *Report) copyFileRange() { r.count(func(r *Report) { r.CopyFileRange++ }) }
+func (r *Report) hardLink()      { r.count(func(r *Report) { r.HardLinks++ }) }
+func (r *Report) resumed()       { r.count(func(r *Report) { r.Resumed++ }) }
+
+func (r *Report) suppressed(src, dest string, err error) {
+	r.count(func(r *Report) { r.Errors = append(r.Errors, ReportError{src, dest, err}) })
+}
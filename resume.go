@@ -0,0 +1,137 @@
+package copy
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ResumeMode represents whether and how to resume copying files from partial files.
+type ResumeMode int
+
+const (
+	// ResumeNever always copies files from the beginning (default behavior).
+	ResumeNever ResumeMode = iota
+	// ResumeTail resumes after checking the last blocks of the partial file
+	// against src by their digests, where unsynced bytes would be broken after a crash.
+	ResumeTail
+	// ResumeFull resumes after checking the whole partial file against src by their digests.
+	ResumeFull
+)
+
+// resumeTailSize is the size of the last blocks which ResumeTail checks.
+const resumeTailSize = 1024 * 1024
+
+// partialName is the name of the partial file, which remains after interruption.
+func partialName(dest string) string {
+	return filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".partial")
+}
+
+// resumed is the partial file opened to append, which disables the fast ways of fcopyContents,
+// because they write from the beginning of the file.
+type resumed struct {
+	WritableFile
+}
+
+// openPartial opens the partial file of dest to write into, and returns the offset to resume from.
+// If the partial file does not match src, it's truncated and the offset is 0.
+func openPartial(src, dest string, info os.FileInfo, opt Options) (WritableFile, string, int64, error) {
+	partial := partialName(dest)
+	if pinfo, err := opt.DestFS.Lstat(partial); err == nil && pinfo.Mode().IsRegular() && pinfo.Size() > 0 && pinfo.Size() <= info.Size() {
+		ok, err := samePrefix(src, partial, pinfo.Size(), opt)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		if ok {
+			f, err := opt.DestFS.OpenFile(partial, os.O_WRONLY|os.O_APPEND, 0666)
+			if err != nil {
+				return nil, "", 0, err
+			}
+			opt.intent.report.resumed()
+			return resumed{f}, partial, pinfo.Size(), nil
+		}
+	}
+	f, err := opt.DestFS.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	return f, partial, 0, err
+}
+
+// commitPartial renames the partial file to dest when everything succeeded,
+// backing up dest right before it's replaced, as commitTemp does.
+// Otherwise the partial file is left to be resumed, unlike commitTemp.
+func commitPartial(partial, dest string, opt Options, reported *error) {
+	if *reported == nil {
+		*reported = backup(dest, opt)
+	}
+	if *reported == nil {
+		*reported = opt.DestFS.Rename(partial, dest)
+	}
+}
+
+// samePrefix reports whether the partial file has the same first size bytes as src,
+// by comparing the digests of the tail blocks or the whole of them, by Resume.
+func samePrefix(src, partial string, size int64, opt Options) (bool, error) {
+	open, ok := destOpener(opt.DestFS)
+	if !ok {
+		return false, nil
+	}
+	from := int64(0)
+	if opt.Resume == ResumeTail && size > resumeTailSize {
+		from = size - resumeTailSize
+	}
+
+	var s io.ReadCloser
+	var err error
+	if opt.FS != nil {
+		s, err = opt.FS.Open(src)
+	} else {
+		s, err = os.Open(src)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer s.Close()
+	srcsum, err := digestRange(s, from, size-from, opt)
+	if err != nil {
+		return false, err
+	}
+
+	p, err := open(partial)
+	if err != nil {
+		return false, err
+	}
+	defer p.Close()
+	partialsum, err := digestRange(p, from, size-from, opt)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(srcsum, partialsum), nil
+}
+
+// digestRange digests n bytes of r from the offset.
+func digestRange(r io.Reader, offset, n int64, opt Options) ([]byte, error) {
+	if err := skipPrefix(r, offset, nil); err != nil {
+		return nil, err
+	}
+	h := newHash(opt)
+	if _, err := io.CopyN(h, r, n); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// skipPrefix skips the first n bytes of r, which are already copied.
+// If h is given, the bytes are read into h, for Verify and Manifest.
+func skipPrefix(r io.Reader, n int64, h hash.Hash) error {
+	if s, ok := r.(io.Seeker); ok && h == nil {
+		_, err := s.Seek(n, io.SeekStart)
+		return err
+	}
+	var w io.Writer = io.Discard
+	if h != nil {
+		w = h
+	}
+	_, err := io.CopyN(w, r, n)
+	return err
+}
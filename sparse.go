@@ -0,0 +1,144 @@
+package copy
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"os"
+)
+
+// SparseMode represents whether or not to make holes in dest files.
+type SparseMode int
+
+const (
+	// SparseNever writes every byte, even if src has holes (default behavior).
+	SparseNever SparseMode = iota
+	// SparseAuto copies only data extents of src, and makes holes for the rest.
+	// Extents are found by SEEK_DATA and SEEK_HOLE only on Linux.
+	SparseAuto
+	// SparseAlways also makes holes for blocks full of zero.
+	SparseAlways
+)
+
+// sparseBlockSize is the unit to find blocks full of zero.
+const sparseBlockSize = 4096
+
+// fcopySparse copies srcfile into f with making holes, if requested by opt.
+// It reports false if f can not have holes, then the loop copies everything.
+// If h is given, the src bytes including holes are digested into h.
+func fcopySparse(f WritableFile, srcfile io.Reader, src string, info os.FileInfo, h hash.Hash, opt Options) (n int64, done bool, err error) {
+	if opt.Sparse == SparseNever {
+		return 0, false, nil
+	}
+	d, ok := f.(*os.File)
+	if !ok {
+		return 0, false, nil
+	}
+
+	var w io.Writer = struct{ io.Writer }{d}
+	if opt.Sparse == SparseAlways {
+		w = holeWriter{d}
+	}
+	size := opt.CopyBufferSize
+	if size == 0 {
+		size = 32 * 1024
+	}
+	buf := make([]byte, size)
+
+	// WrapReader may change the length, so the offsets of extents make no sense.
+	// RateLimit needs the bytes read through fcopyReader.
+	if s, ok := srcfile.(*os.File); ok && opt.WrapReader == nil && opt.RateLimit <= 0 {
+		n, err = copyExtents(w, d, s, src, info.Size(), buf, h, opt)
+	} else {
+		n, err = io.CopyBuffer(w, fcopyReader(digesting(srcfile, h), src, opt), buf)
+	}
+	if err != nil {
+		if err == opt.intent.ctx.Err() {
+			return n, true, canceled(src, err)
+		}
+		return n, true, err
+	}
+
+	// Holes at the end can be made only by truncating.
+	return n, true, d.Truncate(n)
+}
+
+// copyExtents copies only data extents of s into the same offsets of d.
+func copyExtents(w io.Writer, d, s *os.File, src string, size int64, buf []byte, h hash.Hash, opt Options) (int64, error) {
+	off := int64(0)
+	for off < size {
+		data, hole, err := nextExtent(s, off, size)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return off, err
+		}
+		if hole > size {
+			hole = size
+		}
+		opt.intent.progress.add(src, data-off, false)
+		digestZero(h, data-off)
+		if _, err := s.Seek(data, io.SeekStart); err != nil {
+			return off, err
+		}
+		if _, err := d.Seek(data, io.SeekStart); err != nil {
+			return off, err
+		}
+		if _, err := io.CopyBuffer(w, fcopyReader(digesting(io.LimitReader(s, hole-data), h), src, opt), buf); err != nil {
+			return data, err
+		}
+		off = hole
+	}
+	opt.intent.progress.add(src, size-off, false)
+	digestZero(h, size-off)
+	return size, nil
+}
+
+// digestZero digests n bytes of zero, which src has in a hole.
+func digestZero(h hash.Hash, n int64) {
+	if h == nil {
+		return
+	}
+	for ; n > sparseBlockSize; n -= sparseBlockSize {
+		h.Write(zeroBlock)
+	}
+	h.Write(zeroBlock[:n])
+}
+
+// holeWriter skips writing blocks full of zero, to leave holes.
+type holeWriter struct {
+	f *os.File
+}
+
+func (w holeWriter) Write(p []byte) (int, error) {
+	next := func(i int) int {
+		if i+sparseBlockSize > len(p) {
+			return len(p)
+		}
+		return i + sparseBlockSize
+	}
+	written := 0
+	for written < len(p) {
+		// Write the run of data blocks at once, and seek over the run of zero blocks.
+		zero := isZero(p[written:next(written)])
+		end := written
+		for end < len(p) && isZero(p[end:next(end)]) == zero {
+			end = next(end)
+		}
+		if zero {
+			if _, err := w.f.Seek(int64(end-written), io.SeekCurrent); err != nil {
+				return written, err
+			}
+		} else if n, err := w.f.Write(p[written:end]); err != nil {
+			return written + n, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+var zeroBlock = make([]byte, sparseBlockSize)
+
+func isZero(b []byte) bool {
+	return bytes.Equal(b, zeroBlock[:len(b)])
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package copy
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// nextExtent finds the next data extent [data, hole) of f from off.
+// It returns io.EOF if there is no more data.
+func nextExtent(f *os.File, off, size int64) (int64, int64, error) {
+	data, err := f.Seek(off, unix.SEEK_DATA)
+	switch {
+	case errors.Is(err, unix.ENXIO):
+		return 0, 0, io.EOF
+	case errors.Is(err, unix.EINVAL), errors.Is(err, unix.EOPNOTSUPP):
+		// The filesystem does not know holes.
+		return off, size, nil
+	case err != nil:
+		return 0, 0, err
+	}
+	hole, err := f.Seek(data, unix.SEEK_HOLE)
+	if err != nil {
+		return 0, 0, err
+	}
+	return data, hole, nil
+}
@@ -0,0 +1,76 @@
+//go:build linux
+
+package copy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	. "github.com/otiai10/mint"
+)
+
+func allocated(t *testing.T, name string) int64 {
+	info, err := os.Stat(name)
+	Expect(t, err).ToBe(nil)
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestOptions_Sparse(t *testing.T) {
+	size := int64(4 * 1024 * 1024)
+	src := filepath.Join(t.TempDir(), "sparse.img")
+	f, err := os.Create(src)
+	Expect(t, err).ToBe(nil)
+	Expect(t, f.Truncate(size)).ToBe(nil)
+	_, err = f.WriteAt([]byte("data in the middle"), size/2)
+	Expect(t, err).ToBe(nil)
+	Expect(t, f.Close()).ToBe(nil)
+	orig, err := os.ReadFile(src)
+	Expect(t, err).ToBe(nil)
+
+	for _, opt := range []Options{
+		{Sparse: SparseAuto},
+		{Sparse: SparseAuto, CopyBufferSize: 512, Sync: true},
+		{Sparse: SparseAlways},
+		{Sparse: SparseAuto, Verify: true, Manifest: &Manifest{}},
+	} {
+		dest := filepath.Join(t.TempDir(), "sparse.img")
+		err := Copy(src, dest, opt)
+		Expect(t, err).ToBe(nil)
+		b, err := os.ReadFile(dest)
+		Expect(t, err).ToBe(nil)
+		Expect(t, bytes.Equal(b, orig)).ToBe(true)
+		Expect(t, allocated(t, dest) < size/2).ToBe(true)
+		if opt.Manifest != nil {
+			sum := sha256.Sum256(orig)
+			Expect(t, opt.Manifest.Entries[0].Digest).ToBe(hex.EncodeToString(sum[:]))
+		}
+	}
+
+	When(t, "SparseNever is given", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "sparse.img")
+		err := Copy(src, dest, Options{Sparse: SparseNever, CopyBufferSize: 512})
+		Expect(t, err).ToBe(nil)
+		Expect(t, allocated(t, dest) >= size).ToBe(true)
+	})
+
+	When(t, "src has blocks full of zero", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "zero.img")
+		content := make([]byte, size)
+		copy(content[size-10:], "tail data")
+		Expect(t, os.WriteFile(src, content, 0o644)).ToBe(nil)
+		Expect(t, allocated(t, src) >= size).ToBe(true)
+
+		dest := filepath.Join(t.TempDir(), "zero.img")
+		err := Copy(src, dest, Options{Sparse: SparseAlways})
+		Expect(t, err).ToBe(nil)
+		b, err := os.ReadFile(dest)
+		Expect(t, err).ToBe(nil)
+		Expect(t, bytes.Equal(b, content)).ToBe(true)
+		Expect(t, allocated(t, dest) < size/2).ToBe(true)
+	})
+}
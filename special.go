@@ -0,0 +1,77 @@
+package copy
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrSpecialFile is the error when OnSpecial returns SpecialError.
+var ErrSpecialFile = errors.New("special file")
+
+var errMknodUnsupported = errors.New("mknod is not supported")
+
+// mknodFS is WritableFS which can create device nodes and sockets.
+type mknodFS interface {
+	Mknod(name string, mode os.FileMode, dev uint64) error
+}
+
+// isSpecial reports whether the file is a device or a socket.
+// Named pipes are not, because they are always recreated.
+func isSpecial(info os.FileInfo) bool {
+	return info.Mode()&(os.ModeDevice|os.ModeSocket) != 0
+}
+
+// onSpecial decides what to do on src.
+// If src is not special, it's read as a regular file.
+// If OnSpecial is nil, devices are read only when Specials is true,
+// and sockets are skipped.
+func onSpecial(src string, info os.FileInfo, opt Options) SpecialAction {
+	switch {
+	case !isSpecial(info):
+		return SpecialRead
+	case opt.OnSpecial != nil:
+		return opt.OnSpecial(src, info)
+	case info.Mode()&os.ModeDevice != 0 && opt.Specials:
+		return SpecialRead
+	default:
+		return SpecialSkip
+	}
+}
+
+// mknod recreates the device node or the socket of src,
+// with the same device number.
+func mknod(src, dest string, info os.FileInfo, opt Options) error {
+	destfs, ok := opt.DestFS.(mknodFS)
+	if !ok {
+		return &fs.PathError{Op: "mknod", Path: dest, Err: errMknodUnsupported}
+	}
+	if err := opt.DestFS.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+	if _, err := opt.DestFS.Lstat(dest); err == nil {
+		if err := opt.DestFS.RemoveAll(dest); err != nil {
+			return err
+		}
+	}
+	if err := destfs.Mknod(dest, info.Mode(), rdevOf(info)); err != nil {
+		return err
+	}
+	// mknod is affected by umask.
+	if err := opt.DestFS.Chmod(dest, info.Mode()); err != nil {
+		return err
+	}
+	if opt.PreserveOwner {
+		if err := preserveOwner(src, dest, info, opt.DestFS); err != nil {
+			return err
+		}
+	}
+	if opt.PreserveTimes {
+		if err := preserveTimes(info, dest, opt.DestFS); err != nil {
+			return err
+		}
+	}
+	opt.intent.report.special()
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package copy
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func (osFS) Mknod(name string, mode os.FileMode, dev uint64) error {
+	m := uint32(mode.Perm())
+	switch {
+	case mode&os.ModeCharDevice != 0:
+		m |= unix.S_IFCHR
+	case mode&os.ModeDevice != 0:
+		m |= unix.S_IFBLK
+	case mode&os.ModeSocket != 0:
+		m |= unix.S_IFSOCK
+	}
+	return unix.Mknod(name, m, int(dev))
+}
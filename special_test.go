@@ -0,0 +1,84 @@
+//go:build linux
+
+package copy
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	. "github.com/otiai10/mint"
+)
+
+func TestOptions_OnSpecial(t *testing.T) {
+	src := t.TempDir()
+	l, err := net.Listen("unix", filepath.Join(src, "app.sock"))
+	Expect(t, err).ToBe(nil)
+	defer l.Close()
+	Expect(t, os.WriteFile(filepath.Join(src, "file.txt"), []byte("special"), 0o644)).ToBe(nil)
+
+	When(t, "OnSpecial is nil", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		report, err := CopyWithReport(src, dest)
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Skipped).ToBe(int64(1))
+		_, err = os.Lstat(filepath.Join(dest, "app.sock"))
+		Expect(t, os.IsNotExist(err)).ToBe(true)
+	})
+
+	When(t, "OnSpecial returns SpecialRecreate for a socket", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		report, err := CopyWithReport(src, dest, Options{
+			OnSpecial: func(string, os.FileInfo) SpecialAction { return SpecialRecreate },
+		})
+		Expect(t, err).ToBe(nil)
+		Expect(t, report.Specials).ToBe(int64(1))
+		info, err := os.Lstat(filepath.Join(dest, "app.sock"))
+		Expect(t, err).ToBe(nil)
+		Expect(t, info.Mode()&os.ModeSocket != 0).ToBe(true)
+	})
+
+	When(t, "OnSpecial returns SpecialError", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		err := Copy(src, dest, Options{
+			OnSpecial: func(string, os.FileInfo) SpecialAction { return SpecialError },
+		})
+		Expect(t, errors.Is(err, ErrSpecialFile)).ToBe(true)
+		ops, err := Plan(src, dest, Options{
+			OnSpecial: func(string, os.FileInfo) SpecialAction { return SpecialRecreate },
+		})
+		Expect(t, err).ToBe(nil)
+		Expect(t, ops[1].Type).ToBe(OpMknod)
+	})
+
+	When(t, "OnSpecial returns SpecialRecreate for a char device", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "null")
+		err := Copy("/dev/null", dest, Options{
+			OnSpecial: func(string, os.FileInfo) SpecialAction { return SpecialRecreate },
+		})
+		if errors.Is(err, syscall.EPERM) {
+			t.Skip("mknod is not permitted:", err)
+		}
+		Expect(t, err).ToBe(nil)
+		orig, err := os.Lstat("/dev/null")
+		Expect(t, err).ToBe(nil)
+		info, err := os.Lstat(dest)
+		Expect(t, err).ToBe(nil)
+		Expect(t, info.Mode()).ToBe(orig.Mode())
+		Expect(t, rdevOf(info)).ToBe(rdevOf(orig))
+	})
+
+	When(t, "OnSpecial returns SpecialRead for a char device", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "null")
+		err := Copy("/dev/null", dest, Options{
+			OnSpecial: func(string, os.FileInfo) SpecialAction { return SpecialRead },
+		})
+		Expect(t, err).ToBe(nil)
+		info, err := os.Lstat(dest)
+		Expect(t, err).ToBe(nil)
+		Expect(t, info.Mode().IsRegular()).ToBe(true)
+	})
+}
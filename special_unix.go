@@ -0,0 +1,15 @@
+//go:build !windows && !plan9
+
+package copy
+
+import (
+	"os"
+	"syscall"
+)
+
+func rdevOf(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Rdev)
+	}
+	return 0
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package copy
+
+import "os"
+
+func rdevOf(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,140 @@
+package copy
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"os"
+	"strings"
+)
+
+// Transform represents how to transform the contents of a file while copying.
+type Transform struct {
+	// Dest is the new dest name, e.g. with ".gz". If empty, dest is not renamed.
+	Dest string
+
+	// Reader wraps the src reader, such as for decompression.
+	Reader func(r io.Reader) (io.Reader, error)
+
+	// Writer wraps the dest writer, such as for compression.
+	// The returned writer is closed when all the contents are written,
+	// before dest is closed.
+	Writer func(w io.Writer) (io.WriteCloser, error)
+}
+
+// TransformFunc decides the Transform of the file, by the src, the dest and the FileInfo.
+// If it returns nil, the file is copied as it is.
+type TransformFunc func(src, dest string, info os.FileInfo) (*Transform, error)
+
+// transformOf returns the Transform of the file, or nil.
+func transformOf(src, dest string, info os.FileInfo, opt Options) (*Transform, error) {
+	if opt.Transform == nil {
+		return nil, nil
+	}
+	return opt.Transform(src, dest, info)
+}
+
+// transformedDest returns the dest renamed by Transform, with the Transform.
+func transformedDest(src, dest string, info os.FileInfo, opt Options) (string, *Transform, error) {
+	tf, err := transformOf(src, dest, info, opt)
+	if err != nil || tf == nil || tf.Dest == "" {
+		return dest, tf, err
+	}
+	return tf.Dest, tf, nil
+}
+
+// transformed is the dest file wrapped by Transform.Writer,
+// which disables the fast ways of fcopyContents.
+type transformed struct {
+	w io.Writer
+	f WritableFile
+}
+
+func (t transformed) Write(p []byte) (int, error) { return t.w.Write(p) }
+func (t transformed) Close() error                { return t.f.Close() }
+func (t transformed) Sync() error                 { return t.f.Sync() }
+
+// countWriter counts the bytes written into dest through Transform.Writer, for Report.Bytes.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// GzipCompress is the TransformFunc which compresses files by gzip, adding ".gz" to dest.
+func GzipCompress(src, dest string, info os.FileInfo) (*Transform, error) {
+	return &Transform{
+		Dest:   dest + ".gz",
+		Writer: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+	}, nil
+}
+
+// GzipDecompress is the TransformFunc which decompresses gzip files, removing ".gz" from dest.
+// Files without ".gz" are copied as they are.
+func GzipDecompress(src, dest string, info os.FileInfo) (*Transform, error) {
+	if !strings.HasSuffix(dest, ".gz") {
+		return nil, nil
+	}
+	return &Transform{
+		Dest:   strings.TrimSuffix(dest, ".gz"),
+		Reader: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	}, nil
+}
+
+// ZlibCompress is the TransformFunc which compresses files by zlib.
+func ZlibCompress(src, dest string, info os.FileInfo) (*Transform, error) {
+	return &Transform{
+		Writer: func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil },
+	}, nil
+}
+
+// ZlibDecompress is the TransformFunc which decompresses zlib files.
+// Because zlib files have no common suffix, it's applied to every file,
+// thus return it from your own TransformFunc only for zlib files if they are mixed.
+func ZlibDecompress(src, dest string, info os.FileInfo) (*Transform, error) {
+	return &Transform{
+		Reader: func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	}, nil
+}
+
+// NormalizeCRLF is the TransformFunc which converts CRLF line endings to LF.
+func NormalizeCRLF(src, dest string, info os.FileInfo) (*Transform, error) {
+	return &Transform{
+		Reader: func(r io.Reader) (io.Reader, error) { return crlfReader{bufio.NewReader(r)}, nil },
+	}, nil
+}
+
+type crlfReader struct {
+	r *bufio.Reader
+}
+
+func (c crlfReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if b == '\r' {
+			if next, err := c.r.Peek(1); err == nil && next[0] == '\n' {
+				continue
+			}
+		}
+		p[n] = b
+		n++
+		// Do not wait for more bytes which are not buffered yet.
+		if c.r.Buffered() == 0 {
+			break
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,89 @@
+package copy
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// UpdateMode represents how to find unchanged files, which are not copied again.
+type UpdateMode int
+
+const (
+	// UpdateNever copies all files (default behavior).
+	UpdateNever UpdateMode = iota
+	// UpdateSizeAndTime skips the file if the size and the mtime are the same as dest,
+	// within UpdateTolerance. PreserveTimes is turned on by this mode,
+	// so that the next copy can find the file unchanged.
+	UpdateSizeAndTime
+	// UpdateContent skips the file if the size and the digest of the contents by Hash are the same as dest.
+	// It needs DestFS which can be read, such as OSFS and MemFS.
+	UpdateContent
+)
+
+var errUpdateUnsupported = errors.New("update needs DestFS which can be read")
+
+// openFS is WritableFS which can be read, such as MemFS.
+type openFS interface {
+	Open(name string) (fs.File, error)
+}
+
+func (osFS) Open(name string) (fs.File, error) { return osOpen(name) }
+
+func osOpen(name string) (fs.File, error) { return os.Open(name) }
+
+// destOpener returns the function to open files of DestFS for reading,
+// if DestFS can be read. It accepts the same paths as DestFS.OpenFile.
+func destOpener(destfs WritableFS) (func(name string) (fs.File, error), bool) {
+	if m, ok := destfs.(*MemFS); ok {
+		return m.open, true
+	}
+	if f, ok := destfs.(openFS); ok {
+		return f.Open, true
+	}
+	return nil, false
+}
+
+// unchanged reports whether dest already has the same contents as src, by Update.
+// Files transformed by tf are never unchanged, because dest can not be compared with src.
+func unchanged(src, dest string, info os.FileInfo, tf *Transform, opt Options) (bool, error) {
+	if opt.Update == UpdateNever || tf != nil {
+		return false, nil
+	}
+	destinfo, err := opt.DestFS.Lstat(dest)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !destinfo.Mode().IsRegular() || destinfo.Size() != info.Size() {
+		return false, nil
+	}
+	switch opt.Update {
+	case UpdateSizeAndTime:
+		diff := info.ModTime().Sub(destinfo.ModTime())
+		return diff <= opt.UpdateTolerance && -diff <= opt.UpdateTolerance, nil
+	case UpdateContent:
+		open, ok := destOpener(opt.DestFS)
+		if !ok {
+			return false, &fs.PathError{Op: "update", Path: dest, Err: errUpdateUnsupported}
+		}
+		var srcsum, destsum []byte
+		if opt.FS != nil {
+			srcsum, err = digestOf(opt.FS.Open, src, opt.Hash)
+		} else {
+			srcsum, err = digestOf(osOpen, src, opt.Hash)
+		}
+		if err != nil {
+			return false, err
+		}
+		if destsum, err = digestOf(open, dest, opt.Hash); err != nil {
+			return false, err
+		}
+		return bytes.Equal(srcsum, destsum), nil
+	default:
+		return false, nil
+	}
+}
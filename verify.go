@@ -0,0 +1,64 @@
+package copy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrVerifyMismatch is the error when the dest file read back by Verify
+// does not have the same digest as the src bytes.
+var ErrVerifyMismatch = errors.New("verify mismatch")
+
+var errVerifyUnsupported = errors.New("verify needs DestFS which can be read")
+
+// SHA256 is the hash for Verify, which is used by default.
+func SHA256() hash.Hash { return sha256.New() }
+
+// BLAKE2b256 is the hash for Verify, faster than SHA256 on 64-bit platforms.
+func BLAKE2b256() hash.Hash {
+	h, _ := blake2b.New256(nil) // Never fails without a key.
+	return h
+}
+
+// newHash returns the hash specified by Hash, or SHA256.
+func newHash(opt Options) hash.Hash {
+	if opt.Hash != nil {
+		return opt.Hash()
+	}
+	return SHA256()
+}
+
+// digesting tees r into h, if h is given for Verify and Manifest.
+func digesting(r io.Reader, h hash.Hash) io.Reader {
+	if h == nil {
+		return r
+	}
+	return io.TeeReader(r, h)
+}
+
+// verify reads dest back, and compares its digest with sum of the src bytes.
+func verify(dest string, sum []byte, opt Options) error {
+	open, ok := destOpener(opt.DestFS)
+	if !ok {
+		return &fs.PathError{Op: "verify", Path: dest, Err: errVerifyUnsupported}
+	}
+	f, err := open(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := newHash(opt)
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return &fs.PathError{Op: "verify", Path: dest, Err: ErrVerifyMismatch}
+	}
+	return nil
+}
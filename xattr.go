@@ -0,0 +1,130 @@
+package copy
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+)
+
+// XattrNamespace represents which extended attributes are copied.
+// They can be combined, e.g. XattrUser | XattrACL.
+type XattrNamespace int
+
+const (
+	// XattrUser is "user.*" attributes.
+	XattrUser XattrNamespace = 1 << iota
+	// XattrTrusted is "trusted.*" attributes, which requires CAP_SYS_ADMIN.
+	XattrTrusted
+	// XattrSecurity is "security.*" attributes, such as SELinux labels and file capabilities.
+	XattrSecurity
+	// XattrACL is "system.posix_acl_access" and "system.posix_acl_default".
+	XattrACL
+	// XattrAll is all of the above.
+	XattrAll = XattrUser | XattrTrusted | XattrSecurity | XattrACL
+)
+
+const (
+	aclAccess  = "system.posix_acl_access"
+	aclDefault = "system.posix_acl_default"
+)
+
+// includes reports whether the attribute of name is in the namespaces.
+func (ns XattrNamespace) includes(name string) bool {
+	switch {
+	case strings.HasPrefix(name, "user."):
+		return ns&XattrUser != 0
+	case strings.HasPrefix(name, "trusted."):
+		return ns&XattrTrusted != 0
+	case strings.HasPrefix(name, "security."):
+		return ns&XattrSecurity != 0
+	case name == aclAccess, name == aclDefault:
+		return ns&XattrACL != 0
+	default:
+		return false
+	}
+}
+
+// xattrFS is WritableFS which can set extended attributes, without following symlinks.
+type xattrFS interface {
+	Lsetxattr(name, attr string, value []byte) error
+}
+
+// preserveXattrs copies the extended attributes of src to dest,
+// which is a file, a directory or a symlink.
+func preserveXattrs(src, dest string, opt Options) error {
+	destfs, ok := opt.DestFS.(xattrFS)
+	if !ok || opt.FS != nil {
+		return nil
+	}
+	names, err := llistxattr(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !opt.PreserveXattrs.includes(name) {
+			continue
+		}
+		value, err := lgetxattr(src, name)
+		if err != nil {
+			return err
+		}
+		if opt.OnXattr != nil {
+			if value, ok = opt.OnXattr(src, name, value); !ok {
+				continue
+			}
+		}
+		if name == aclAccess {
+			// Setting the ACL also sets the mode, so keep it as PermissionControl made.
+			info, err := opt.DestFS.Lstat(dest)
+			if err != nil {
+				return err
+			}
+			value = aclWithMode(value, info.Mode())
+		}
+		if err := destfs.Lsetxattr(dest, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ACL entry tags of system.posix_acl_access.
+// See linux/posix_acl_xattr.h.
+const (
+	aclUserObj  = 0x01
+	aclGroupObj = 0x04
+	aclMask     = 0x10
+	aclOther    = 0x20
+
+	aclHeaderSize = 4
+	aclEntrySize  = 8
+)
+
+// aclWithMode rewrites the permissions of the owner, the mask (or the group if no mask)
+// and the others in the ACL, to be consistent with the mode.
+// The value is returned as it is, if it's not a valid ACL.
+func aclWithMode(value []byte, mode os.FileMode) []byte {
+	if len(value) < aclHeaderSize || (len(value)-aclHeaderSize)%aclEntrySize != 0 {
+		return value
+	}
+	hasMask := false
+	for i := aclHeaderSize; i < len(value); i += aclEntrySize {
+		if binary.LittleEndian.Uint16(value[i:]) == aclMask {
+			hasMask = true
+		}
+	}
+	acl := make([]byte, len(value))
+	copy(acl, value)
+	perm := uint16(mode.Perm())
+	for i := aclHeaderSize; i < len(acl); i += aclEntrySize {
+		switch tag := binary.LittleEndian.Uint16(acl[i:]); {
+		case tag == aclUserObj:
+			binary.LittleEndian.PutUint16(acl[i+2:], perm>>6&7)
+		case tag == aclMask, tag == aclGroupObj && !hasMask:
+			binary.LittleEndian.PutUint16(acl[i+2:], perm>>3&7)
+		case tag == aclOther:
+			binary.LittleEndian.PutUint16(acl[i+2:], perm&7)
+		}
+	}
+	return acl
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package copy
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/otiai10/mint"
+	"golang.org/x/sys/unix"
+)
+
+// acl builds the value of system.posix_acl_access from pairs of tag and perm.
+func acl(entries ...uint16) []byte {
+	value := []byte{2, 0, 0, 0}
+	for i := 0; i < len(entries); i += 2 {
+		entry := make([]byte, aclEntrySize)
+		binary.LittleEndian.PutUint16(entry, entries[i])
+		binary.LittleEndian.PutUint16(entry[2:], entries[i+1])
+		binary.LittleEndian.PutUint32(entry[4:], 0xFFFFFFFF)
+		if entries[i] == 0x02 { // ACL_USER
+			binary.LittleEndian.PutUint32(entry[4:], 1000)
+		}
+		value = append(value, entry...)
+	}
+	return value
+}
+
+func TestOptions_PreserveXattrs(t *testing.T) {
+	src := t.TempDir()
+	file, dir := filepath.Join(src, "file.txt"), filepath.Join(src, "dir")
+	Expect(t, os.WriteFile(file, []byte("xattr"), 0o640)).ToBe(nil)
+	Expect(t, os.Mkdir(dir, 0o755)).ToBe(nil)
+	if err := unix.Setxattr(file, "user.foo", []byte("bar"), 0); errors.Is(err, unix.ENOTSUP) {
+		t.Skip("user xattr is not supported:", err)
+	}
+	Expect(t, unix.Setxattr(file, "user.drop", []byte("me"), 0)).ToBe(nil)
+	Expect(t, unix.Setxattr(dir, "user.baz", []byte("qux"), 0)).ToBe(nil)
+	Expect(t, os.Symlink("file.txt", filepath.Join(src, "symlink"))).ToBe(nil)
+
+	When(t, "PreserveXattrs is XattrUser", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		err := Copy(src, dest, Options{
+			PreserveXattrs: XattrUser,
+			OnXattr: func(src, name string, value []byte) ([]byte, bool) {
+				if name == "user.foo" {
+					return append(value, '!'), true
+				}
+				return value, name != "user.drop"
+			},
+		})
+		Expect(t, err).ToBe(nil)
+		value, err := lgetxattr(filepath.Join(dest, "file.txt"), "user.foo")
+		Expect(t, err).ToBe(nil)
+		Expect(t, string(value)).ToBe("bar!")
+		_, err = lgetxattr(filepath.Join(dest, "file.txt"), "user.drop")
+		Expect(t, errors.Is(err, unix.ENODATA)).ToBe(true)
+		value, err = lgetxattr(filepath.Join(dest, "dir"), "user.baz")
+		Expect(t, err).ToBe(nil)
+		Expect(t, string(value)).ToBe("qux")
+	})
+
+	When(t, "PreserveXattrs is zero", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		Expect(t, Copy(src, dest)).ToBe(nil)
+		names, err := llistxattr(filepath.Join(dest, "file.txt"))
+		Expect(t, err).ToBe(nil)
+		Expect(t, len(names)).ToBe(0)
+	})
+
+	When(t, "PermissionControl adds bits to the file with ACL", func(t *testing.T) {
+		value := acl(aclUserObj, 6, 0x02, 4, aclGroupObj, 4, aclMask, 4, aclOther, 0)
+		if err := unix.Setxattr(file, aclAccess, value, 0); errors.Is(err, unix.ENOTSUP) {
+			t.Skip("ACL is not supported:", err)
+		}
+		dest := filepath.Join(t.TempDir(), "file.txt")
+		err := Copy(file, dest, Options{PreserveXattrs: XattrACL, PermissionControl: AddPermission(0o020)})
+		Expect(t, err).ToBe(nil)
+		info, err := os.Stat(dest)
+		Expect(t, err).ToBe(nil)
+		Expect(t, info.Mode().Perm()).ToBe(os.FileMode(0o660))
+		value, err = lgetxattr(dest, aclAccess)
+		Expect(t, err).ToBe(nil)
+		Expect(t, value).ToBe(acl(aclUserObj, 6, 0x02, 4, aclGroupObj, 4, aclMask, 6, aclOther, 0))
+	})
+}
+
+func TestAclWithMode(t *testing.T) {
+	value := acl(aclUserObj, 6, aclGroupObj, 4, aclOther, 4)
+	Expect(t, aclWithMode(value, 0o751)).ToBe(acl(aclUserObj, 7, aclGroupObj, 5, aclOther, 1))
+	Expect(t, aclWithMode([]byte{2, 0}, 0o751)).ToBe([]byte{2, 0})
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package copy
+
+// TODO: Support extended attributes on other platforms, such as darwin and freebsd.
+func llistxattr(path string) ([]string, error) {
+	return nil, nil
+}
+
+func lgetxattr(path, attr string) ([]byte, error) {
+	return nil, nil
+}